@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ACCOUNT_CACHE_TTL is how long an answer is trusted for. Answers we
+	// get from extended-join and account-notify are kept up to date by the
+	// server, so this mostly matters without them.
+	ACCOUNT_CACHE_TTL = 10 * time.Minute
+	// ACCOUNT_LOOKUP_TIMEOUT is how long we wait for the server or NickServ
+	// to answer.
+	ACCOUNT_LOOKUP_TIMEOUT = 10 * time.Second
+)
+
+// accountEntry is what we know about a nick's services account. An empty
+// account means they aren't logged in.
+type accountEntry struct {
+	account string
+	checked time.Time
+}
+
+// accountCache remembers which services account each nick is logged in to,
+// and the lookups waiting on an answer from the server. Only nicks in a
+// channel with us are remembered: anyone else could quit and have their
+// nick taken without us seeing, so they're looked up every time.
+type accountCache struct {
+	mu       sync.Mutex
+	accounts map[string]accountEntry
+	pending  map[string][]chan string
+	// whoisAccount collects the 330 for a WHOIS until its 318.
+	whoisAccount map[string]string
+}
+
+func newAccountCache() *accountCache {
+	return &accountCache{
+		accounts:     make(map[string]accountEntry),
+		pending:      make(map[string][]chan string),
+		whoisAccount: make(map[string]string),
+	}
+}
+
+// setAccount records nick's account ("" or "*" for none) and wakes anyone
+// waiting to find out.
+func (b *IRCBot) setAccount(nick, account string) {
+	if account == "*" {
+		account = ""
+	}
+	key := strings.ToLower(nick)
+	shared := b.sharesChannel(nick)
+
+	b.accounts.mu.Lock()
+	if shared {
+		b.accounts.accounts[key] = accountEntry{account: account, checked: time.Now()}
+	} else {
+		delete(b.accounts.accounts, key)
+	}
+	waiters := b.accounts.pending[key]
+	delete(b.accounts.pending, key)
+	b.accounts.mu.Unlock()
+
+	for _, waiter := range waiters {
+		waiter <- account
+	}
+}
+
+// forgetAccount drops what we know about nick, e.g. when they quit.
+func (b *IRCBot) forgetAccount(nick string) {
+	b.accounts.mu.Lock()
+	delete(b.accounts.accounts, strings.ToLower(nick))
+	b.accounts.mu.Unlock()
+}
+
+// pruneAccounts forgets the accounts of nicks we no longer share a channel
+// with, e.g. after we leave one.
+func (b *IRCBot) pruneAccounts() {
+	b.accounts.mu.Lock()
+	defer b.accounts.mu.Unlock()
+
+	for key := range b.accounts.accounts {
+		if !b.sharesChannel(key) {
+			delete(b.accounts.accounts, key)
+		}
+	}
+}
+
+// renameAccount follows a nick change. Changing nick doesn't log anyone
+// out, so the account carries over.
+func (b *IRCBot) renameAccount(oldNick, newNick string) {
+	b.accounts.mu.Lock()
+	defer b.accounts.mu.Unlock()
+
+	if entry, ok := b.accounts.accounts[strings.ToLower(oldNick)]; ok {
+		delete(b.accounts.accounts, strings.ToLower(oldNick))
+		b.accounts.accounts[strings.ToLower(newNick)] = entry
+	}
+}
+
+// accountFor returns the services account the sender of message is logged
+// in to, or "" if they aren't. With account-tag the server tells us on
+// every message; otherwise we use what we've cached from extended-join and
+// account-notify, and ask the server or NickServ if that's stale.
+func (b *IRCBot) accountFor(ctx context.Context, message *Message) (string, error) {
+	if b.HasCap("account-tag") {
+		return message.Tags["account"], nil
+	}
+
+	nick := message.Nick()
+	key := strings.ToLower(nick)
+
+	b.accounts.mu.Lock()
+	if entry, ok := b.accounts.accounts[key]; ok && time.Since(entry.checked) < ACCOUNT_CACHE_TTL {
+		b.accounts.mu.Unlock()
+		return entry.account, nil
+	}
+
+	// Share one query between everyone asking about the same nick.
+	waiter := make(chan string, 1)
+	first := len(b.accounts.pending[key]) == 0
+	b.accounts.pending[key] = append(b.accounts.pending[key], waiter)
+	b.accounts.mu.Unlock()
+
+	if first {
+		switch check := b.currentConfig().Bot.AccountCheck; check {
+		case "ACC", "STATUS":
+			b.sendRaw(fmt.Sprintf("PRIVMSG NickServ :%s %s", check, nick))
+		default:
+			b.sendRaw("WHOIS " + nick)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ACCOUNT_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	select {
+	case account := <-waiter:
+		return account, nil
+	case <-ctx.Done():
+		b.accounts.mu.Lock()
+		waiters := b.accounts.pending[key]
+		for i, w := range waiters {
+			if w == waiter {
+				b.accounts.pending[key] = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(b.accounts.pending[key]) == 0 {
+			delete(b.accounts.pending, key)
+		}
+		b.accounts.mu.Unlock()
+		return "", fmt.Errorf("checking %s's account: %w", nick, ctx.Err())
+	}
+}
+
+// handleAccountMessage keeps the account cache up to date from the server.
+// It's called from the read loop for extended-join JOINs, account-notify
+// ACCOUNT messages, WHOIS replies and NickServ notices.
+func (b *IRCBot) handleAccountMessage(message *Message) {
+	switch message.Command {
+	case "JOIN":
+		// With extended-join: JOIN <channel> <account> :<realname>
+		if b.HasCap("extended-join") && len(message.Params) >= 3 {
+			b.setAccount(message.Nick(), message.Param(1))
+		}
+
+	case "ACCOUNT":
+		b.setAccount(message.Nick(), message.Param(0))
+
+	case "330":
+		// RPL_WHOISACCOUNT: <me> <nick> <account> :is logged in as
+		b.accounts.mu.Lock()
+		b.accounts.whoisAccount[strings.ToLower(message.Param(1))] = message.Param(2)
+		b.accounts.mu.Unlock()
+
+	case "318":
+		// RPL_ENDOFWHOIS: no 330 by now means they aren't logged in.
+		key := strings.ToLower(message.Param(1))
+
+		b.accounts.mu.Lock()
+		account := b.accounts.whoisAccount[key]
+		delete(b.accounts.whoisAccount, key)
+		b.accounts.mu.Unlock()
+
+		b.setAccount(message.Param(1), account)
+
+	case "NOTICE":
+		if strings.EqualFold(message.Nick(), "NickServ") {
+			if nick, account, ok := parseNickServStatus(message.Trailing()); ok {
+				b.setAccount(nick, account)
+			}
+		}
+	}
+}
+
+// parseNickServStatus reads NickServ's answer to ACC or STATUS. Atheme
+// replies "nick ACC 3" or "nick -> account ACC 3", and Anope "STATUS nick 3
+// account". Level 3 means identified; anything else means not logged in.
+func parseNickServStatus(text string) (nick, account string, ok bool) {
+	fields := strings.Fields(text)
+
+	var level string
+	switch {
+	case len(fields) >= 3 && fields[0] == "STATUS":
+		nick, level = fields[1], fields[2]
+		account = nick
+		if len(fields) >= 4 {
+			account = fields[3]
+		}
+	case len(fields) >= 3 && fields[len(fields)-2] == "ACC":
+		nick, level = fields[0], fields[len(fields)-1]
+		account = nick
+		if len(fields) >= 5 && fields[1] == "->" {
+			account = fields[2]
+		}
+	default:
+		return "", "", false
+	}
+
+	if level != "3" {
+		account = ""
+	}
+	return nick, account, true
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ArgKind is the type a command argument or flag value must have.
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgInt
+	ArgURL
+	ArgNick
+	// ArgBool is only valid for flags, which then take no value.
+	ArgBool
+)
+
+// ArgSpec declares one positional argument.
+type ArgSpec struct {
+	Name     string
+	Kind     ArgKind
+	Optional bool
+	// Rest collects every remaining word into this argument. It must be
+	// the last one.
+	Rest bool
+}
+
+// FlagSpec declares a --name option.
+type FlagSpec struct {
+	Name    string
+	Kind    ArgKind
+	Default string
+}
+
+// Signature declares the arguments a command takes. The usage line and
+// argument errors are generated from it.
+type Signature struct {
+	Args  []ArgSpec
+	Flags []FlagSpec
+}
+
+// UsageError explains why a command's arguments were rejected.
+type UsageError struct {
+	Reason string
+}
+
+func (e *UsageError) Error() string {
+	return e.Reason
+}
+
+// Is lets errors.Is(err, errUsage) match any UsageError.
+func (e *UsageError) Is(target error) bool {
+	return target == errUsage
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &UsageError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// Usage returns the argument synopsis, e.g.
+// "[--days <number>] <location...>". Flags come before a Rest argument,
+// since parse treats everything after its start as part of it.
+func (s Signature) Usage() string {
+	var parts, flags []string
+
+	for _, flag := range s.Flags {
+		if flag.Kind == ArgBool {
+			flags = append(flags, "[--"+flag.Name+"]")
+		} else {
+			flags = append(flags, fmt.Sprintf("[--%s <%s>]", flag.Name, kindName(flag.Kind)))
+		}
+	}
+
+	for _, arg := range s.Args {
+		if arg.Rest {
+			parts = append(parts, flags...)
+			flags = nil
+		}
+
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+
+	return strings.Join(append(parts, flags...), " ")
+}
+
+func kindName(kind ArgKind) string {
+	switch kind {
+	case ArgInt:
+		return "number"
+	case ArgURL:
+		return "url"
+	case ArgNick:
+		return "nick"
+	default:
+		return "value"
+	}
+}
+
+// tokenize splits a command line into words. Double or single quotes at the
+// start of a word group words together, so apostrophes inside words are
+// left alone, and a backslash escapes the next character outside single
+// quotes.
+func tokenize(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && !inWord:
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, usageErrorf("missing closing %c", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// checkKind validates value as kind.
+func checkKind(name string, kind ArgKind, value string) error {
+	switch kind {
+	case ArgInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return usageErrorf("%s must be a number, not %q", name, value)
+		}
+	case ArgURL:
+		if !isValidURL(value) {
+			return usageErrorf("%s must be an http or https URL, not %q", name, value)
+		}
+	case ArgNick:
+		if value == "" || strings.ContainsAny(value, " ,*?!@#&:") {
+			return usageErrorf("%q isn't a valid nick", value)
+		}
+	}
+	return nil
+}
+
+// Args holds a command's parsed arguments and flags.
+type Args struct {
+	values map[string]string
+	set    map[string]bool
+}
+
+// parse matches words against the signature.
+func (s Signature) parse(words []string) (*Args, error) {
+	args := &Args{
+		values: make(map[string]string),
+		set:    make(map[string]bool),
+	}
+
+	flags := make(map[string]FlagSpec)
+	for _, flag := range s.Flags {
+		flags[flag.Name] = flag
+		if flag.Default != "" {
+			args.values[flag.Name] = flag.Default
+		}
+	}
+
+	restAt := -1
+	for i, spec := range s.Args {
+		if spec.Rest {
+			restAt = i
+			break
+		}
+	}
+
+	// Pull the flags out first, leaving the positional words. Once a Rest
+	// argument has started, the remaining words are all part of it, so free
+	// text can contain anything.
+	var positional []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+
+		if len(flags) == 0 || (restAt >= 0 && len(positional) > restAt) {
+			positional = append(positional, words[i:]...)
+			break
+		}
+		if word == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(word, "--") || len(word) == 2 {
+			positional = append(positional, word)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(word[2:], "=")
+		flag, ok := flags[name]
+		if !ok {
+			return nil, usageErrorf("unknown option --%s", name)
+		}
+
+		if flag.Kind == ArgBool {
+			if hasValue {
+				return nil, usageErrorf("--%s doesn't take a value", name)
+			}
+			value = "true"
+		} else if !hasValue {
+			if i+1 >= len(words) {
+				return nil, usageErrorf("--%s needs a value", name)
+			}
+			i++
+			value = words[i]
+		}
+
+		if err := checkKind("--"+name, flag.Kind, value); err != nil {
+			return nil, err
+		}
+		args.values[name] = value
+		args.set[name] = true
+	}
+
+	for _, spec := range s.Args {
+		if len(positional) == 0 {
+			if !spec.Optional {
+				return nil, usageErrorf("missing %s", spec.Name)
+			}
+			continue
+		}
+
+		value := positional[0]
+		positional = positional[1:]
+		if spec.Rest {
+			value = strings.Join(append([]string{value}, positional...), " ")
+			positional = nil
+		}
+
+		if err := checkKind(spec.Name, spec.Kind, value); err != nil {
+			return nil, err
+		}
+		args.values[spec.Name] = value
+		args.set[spec.Name] = true
+	}
+
+	if len(positional) > 0 {
+		return nil, usageErrorf("too many arguments")
+	}
+
+	return args, nil
+}
+
+// Has reports whether the argument or flag was given.
+func (a *Args) Has(name string) bool {
+	return a.set[name]
+}
+
+// String returns an argument or flag value, or its default.
+func (a *Args) String(name string) string {
+	return a.values[name]
+}
+
+// Int returns a numeric argument or flag. It's already been validated, so
+// this only returns 0 if it wasn't given and has no default.
+func (a *Args) Int(name string) int {
+	n, _ := strconv.Atoi(a.values[name])
+	return n
+}
+
+// Bool returns whether a boolean flag was given.
+func (a *Args) Bool(name string) bool {
+	return a.values[name] == "true"
+}
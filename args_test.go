@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"  one   two\tthree ", []string{"one", "two", "three"}},
+		{`"New South Wales" 3`, []string{"New South Wales", "3"}},
+		{`'single quoted' word`, []string{"single quoted", "word"}},
+		{`it's a great read`, []string{"it's", "a", "great", "read"}},
+		{`don't "quote me"`, []string{"don't", "quote me"}},
+		{`say "it's fine"`, []string{"say", "it's fine"}},
+		{`back\ slash \"quoted\"`, []string{"back slash", `"quoted"`}},
+		{`'no \escapes'`, []string{`no \escapes`}},
+		{`""`, []string{""}},
+		{`a"b"c`, []string{`a"b"c`}},
+	}
+
+	for _, test := range tests {
+		got, err := tokenize(test.text)
+		if err != nil {
+			t.Errorf("tokenize(%q) failed: %v", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestTokenizeUnclosedQuote(t *testing.T) {
+	for _, text := range []string{`"open`, `one 'two`} {
+		if _, err := tokenize(text); !errors.Is(err, errUsage) {
+			t.Errorf("tokenize(%q) error = %v, want a usage error", text, err)
+		}
+	}
+}
+
+func TestSignatureParse(t *testing.T) {
+	relay := Signature{Args: []ArgSpec{
+		{Name: "user", Kind: ArgNick},
+		{Name: "url", Kind: ArgURL},
+		{Name: "description", Optional: true, Rest: true},
+	}}
+	weather := Signature{
+		Args: []ArgSpec{{Name: "location", Optional: true, Rest: true}},
+		Flags: []FlagSpec{
+			{Name: "days", Kind: ArgInt, Default: "1"},
+			{Name: "verbose", Kind: ArgBool},
+		},
+	}
+
+	tests := []struct {
+		sig   Signature
+		words []string
+		want  map[string]string
+	}{
+		{relay, []string{"bob", "https://example.com"}, map[string]string{"user": "bob", "url": "https://example.com"}},
+		{relay, []string{"bob", "https://example.com", "it's", "a", "great", "read"}, map[string]string{"user": "bob", "url": "https://example.com", "description": "it's a great read"}},
+		{weather, nil, map[string]string{"days": "1"}},
+		{weather, []string{"--days", "3", "Sydney"}, map[string]string{"location": "Sydney", "days": "3"}},
+		{weather, []string{"Sydney", "--days", "3"}, map[string]string{"location": "Sydney --days 3", "days": "1"}},
+		{weather, []string{"--days=2", "--verbose", "Blue", "Mountains"}, map[string]string{"location": "Blue Mountains", "days": "2", "verbose": "true"}},
+		{weather, []string{"--", "--days"}, map[string]string{"location": "--days", "days": "1"}},
+		// Without flags, nothing looks like an option.
+		{relay, []string{"bob", "https://example.com", "--", "see", "--above"}, map[string]string{"user": "bob", "url": "https://example.com", "description": "-- see --above"}},
+		{relay, []string{"--bob", "https://example.com"}, map[string]string{"user": "--bob", "url": "https://example.com"}},
+	}
+
+	for _, test := range tests {
+		args, err := test.sig.parse(test.words)
+		if err != nil {
+			t.Errorf("parse(%q) failed: %v", test.words, err)
+			continue
+		}
+		if !reflect.DeepEqual(args.values, test.want) {
+			t.Errorf("parse(%q) = %q, want %q", test.words, args.values, test.want)
+		}
+	}
+}
+
+func TestSignatureParseErrors(t *testing.T) {
+	sig := Signature{
+		Args: []ArgSpec{
+			{Name: "user", Kind: ArgNick},
+			{Name: "count", Kind: ArgInt, Optional: true},
+		},
+		Flags: []FlagSpec{{Name: "quiet", Kind: ArgBool}, {Name: "url", Kind: ArgURL}},
+	}
+
+	tests := []struct {
+		words  []string
+		reason string
+	}{
+		{nil, "missing user"},
+		{[]string{"#chan"}, `"#chan" isn't a valid nick`},
+		{[]string{"bob", "many"}, `count must be a number, not "many"`},
+		{[]string{"bob", "1", "2"}, "too many arguments"},
+		{[]string{"bob", "--loud"}, "unknown option --loud"},
+		{[]string{"bob", "--quiet=yes"}, "--quiet doesn't take a value"},
+		{[]string{"bob", "--url"}, "--url needs a value"},
+		{[]string{"bob", "--url", "ftp://x"}, `--url must be an http or https URL, not "ftp://x"`},
+	}
+
+	for _, test := range tests {
+		_, err := sig.parse(test.words)
+		var usageErr *UsageError
+		if !errors.As(err, &usageErr) {
+			t.Errorf("parse(%q) error = %v, want a usage error", test.words, err)
+			continue
+		}
+		if usageErr.Reason != test.reason {
+			t.Errorf("parse(%q) reason = %q, want %q", test.words, usageErr.Reason, test.reason)
+		}
+	}
+}
+
+func TestSignatureUsage(t *testing.T) {
+	sig := Signature{
+		Args: []ArgSpec{
+			{Name: "user"},
+			{Name: "description", Optional: true, Rest: true},
+		},
+		Flags: []FlagSpec{{Name: "days", Kind: ArgInt}, {Name: "quiet", Kind: ArgBool}},
+	}
+
+	want := "<user> [--days <number>] [--quiet] [description...]"
+	if got := sig.Usage(); got != want {
+		t.Errorf("Usage() = %q, want %q", got, want)
+	}
+
+	sig.Args = sig.Args[:1]
+	want = "<user> [--days <number>] [--quiet]"
+	if got := sig.Usage(); got != want {
+		t.Errorf("Usage() = %q, want %q", got, want)
+	}
+}
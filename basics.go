@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type helloCommand struct{ commandInfo }
+
+func (helloCommand) Run(ctx context.Context, req *Request) error {
+	req.Reply("Hello, world!")
+	return nil
+}
+
+type pingCommand struct{ commandInfo }
+
+func (pingCommand) Run(ctx context.Context, req *Request) error {
+	req.Reply("pong")
+	return nil
+}
+
+type timeCommand struct{ commandInfo }
+
+func (timeCommand) Run(ctx context.Context, req *Request) error {
+	req.Reply(time.Now().String())
+	return nil
+}
+
+func init() {
+	commands.register(helloCommand{commandInfo{
+		name:        "hello",
+		description: "Say hello.",
+		permission:  RoleTrusted,
+	}})
+	commands.register(pingCommand{commandInfo{
+		name:        "ping",
+		description: "Will return 'pong'.",
+		permission:  RoleTrusted,
+	}})
+	commands.register(timeCommand{commandInfo{
+		name:        "time",
+		description: "Will return the current time.",
+		permission:  RoleTrusted,
+	}})
+}
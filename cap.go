@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// wantedCaps are the IRCv3 capabilities the bot will request if the server
+// offers them.
+var wantedCaps = []string{
+	"message-tags",
+	"server-time",
+	"account-tag",
+	"extended-join",
+	"account-notify",
+	"away-notify",
+	"echo-message",
+	"multi-prefix",
+}
+
+// HasCap reports whether the server acknowledged the named capability.
+func (b *IRCBot) HasCap(name string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.caps[name]
+}
+
+// readMessage reads and parses the next line from the server. It is used
+// while registering, before receiveMessages takes over the connection.
+func (b *IRCBot) readMessage() (*Message, error) {
+	for b.scanner.Scan() {
+		line := b.scanner.Text()
+		fmt.Println("Received:", line)
+
+		message, err := parseMessage(line)
+		if err != nil {
+			fmt.Println("Error parsing message:", err)
+			continue
+		}
+		return message, nil
+	}
+
+	if err := b.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("connection closed by server")
+}
+
+// parseCapList splits a CAP LS/ACK list into capability names and values.
+func parseCapList(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, item := range strings.Fields(list) {
+		name, value, _ := strings.Cut(item, "=")
+		caps[name] = value
+	}
+	return caps
+}
+
+// requestCaps sends a CAP REQ for every wanted capability in available. It
+// returns false if there was nothing to request.
+func (b *IRCBot) requestCaps(available map[string]string) bool {
+	wanted := wantedCaps
+	if b.sasl != nil {
+		wanted = append(wanted[:len(wanted):len(wanted)], "sasl")
+	}
+
+	var request []string
+	for _, name := range wanted {
+		if _, ok := available[name]; ok && !b.HasCap(name) {
+			request = append(request, name)
+		}
+	}
+
+	if len(request) == 0 {
+		return false
+	}
+
+	b.sendRaw("CAP REQ :" + strings.Join(request, " "))
+	return true
+}
+
+// negotiateCaps runs CAP LS 302 / CAP REQ / CAP END as part of registration.
+// The CAP LS must already have been sent, ahead of NICK and USER.
+func (b *IRCBot) negotiateCaps() error {
+	available := make(map[string]string)
+	pending := false
+
+	for {
+		message, err := b.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch message.Command {
+		case "PING":
+			b.sendRaw("PONG :" + message.Trailing())
+
+		case "CAP":
+			switch strings.ToUpper(message.Param(1)) {
+			case "LS":
+				for name, value := range parseCapList(message.Trailing()) {
+					available[name] = value
+				}
+				// A "*" before the list means more LS lines are coming.
+				if message.Param(2) == "*" {
+					continue
+				}
+				b.mu.Lock()
+				b.availableCaps = available
+				b.mu.Unlock()
+
+				pending = b.requestCaps(available)
+
+			case "ACK":
+				b.handleCap(message)
+				pending = false
+
+			case "NAK":
+				fmt.Println("Server refused capabilities:", message.Trailing())
+				pending = false
+			}
+
+			if !pending {
+				if b.sasl != nil {
+					if !b.HasCap("sasl") {
+						return fmt.Errorf("%w: SASL is configured but the server did not offer it", errSASLFailed)
+					}
+					if err := b.authenticate(); err != nil {
+						return err
+					}
+				}
+				b.sendRaw("CAP END")
+				return nil
+			}
+
+		case "001", "421":
+			// The server doesn't do capability negotiation.
+			if b.sasl != nil {
+				return errors.New("SASL is configured but the server does not support CAP")
+			}
+			if message.Command == "001" {
+				b.handleWelcome(message)
+			}
+			return nil
+
+		default:
+			if err := b.registrationError(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleCap keeps the enabled capability set up to date with ACK, NEW and
+// DEL messages from the server.
+func (b *IRCBot) handleCap(message *Message) {
+	switch strings.ToUpper(message.Param(1)) {
+	case "ACK":
+		b.mu.Lock()
+		for name := range parseCapList(message.Trailing()) {
+			if strings.HasPrefix(name, "-") {
+				delete(b.caps, name[1:])
+			} else {
+				b.caps[name] = true
+			}
+		}
+		b.mu.Unlock()
+
+	case "NEW":
+		available := parseCapList(message.Trailing())
+		b.mu.Lock()
+		for name, value := range available {
+			b.availableCaps[name] = value
+		}
+		b.mu.Unlock()
+		b.requestCaps(available)
+
+	case "DEL":
+		b.mu.Lock()
+		for name := range parseCapList(message.Trailing()) {
+			delete(b.caps, name)
+			delete(b.availableCaps, name)
+		}
+		b.mu.Unlock()
+	}
+}
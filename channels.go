@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ChannelSettings control what the bot does in one channel. They're stored
+// in channel_settings and edited with !channel.
+type ChannelSettings struct {
+	Channel string
+	Greet   bool
+	// Prefixes replace the configured command prefixes when set.
+	Prefixes []string
+	// Disabled lists the commands that can't be used in the channel.
+	Disabled []string
+	// Language picks which greetings are used, e.g. "fr". Empty means
+	// any of them.
+	Language string
+}
+
+// defaultChannelSettings returns the settings for a channel nobody has
+// changed anything in.
+func defaultChannelSettings(channel string) ChannelSettings {
+	return ChannelSettings{Channel: strings.ToLower(channel), Greet: true}
+}
+
+// allows reports whether command can be used in the channel.
+func (s ChannelSettings) allows(command string) bool {
+	for _, disabled := range s.Disabled {
+		if strings.EqualFold(disabled, command) {
+			return false
+		}
+	}
+	return true
+}
+
+// setAllowed enables or disables command in the channel.
+func (s *ChannelSettings) setAllowed(command string, allowed bool) {
+	command = strings.ToLower(command)
+
+	var disabled []string
+	for _, name := range s.Disabled {
+		if name != command {
+			disabled = append(disabled, name)
+		}
+	}
+	if !allowed {
+		disabled = append(disabled, command)
+	}
+	s.Disabled = disabled
+}
+
+// channelSettingsCache holds every channel's settings in memory, since
+// they're needed on the read loop for each message.
+type channelSettingsCache struct {
+	mu        sync.RWMutex
+	byChannel map[string]ChannelSettings
+}
+
+func newChannelSettingsCache() *channelSettingsCache {
+	return &channelSettingsCache{byChannel: make(map[string]ChannelSettings)}
+}
+
+// loadChannelSettings reads every channel's settings into memory.
+func (b *IRCBot) loadChannelSettings(ctx context.Context) error {
+	settings, err := DB.ChannelSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.channelSettings.mu.Lock()
+	defer b.channelSettings.mu.Unlock()
+
+	b.channelSettings.byChannel = make(map[string]ChannelSettings)
+	for _, channel := range settings {
+		b.channelSettings.byChannel[strings.ToLower(channel.Channel)] = channel
+	}
+	return nil
+}
+
+// settingsFor returns the settings for channel.
+func (b *IRCBot) settingsFor(channel string) ChannelSettings {
+	b.channelSettings.mu.RLock()
+	defer b.channelSettings.mu.RUnlock()
+
+	if settings, ok := b.channelSettings.byChannel[strings.ToLower(channel)]; ok {
+		return settings
+	}
+	return defaultChannelSettings(channel)
+}
+
+// updateChannelSettings saves settings and starts using them straight away.
+func (b *IRCBot) updateChannelSettings(ctx context.Context, settings ChannelSettings) error {
+	if err := DB.SaveChannelSettings(ctx, settings); err != nil {
+		return err
+	}
+
+	b.channelSettings.mu.Lock()
+	b.channelSettings.byChannel[strings.ToLower(settings.Channel)] = settings
+	b.channelSettings.mu.Unlock()
+	return nil
+}
+
+// commandPrefixes returns the prefixes that mark a command in channel.
+func (b *IRCBot) commandPrefixes(channel string) []string {
+	if settings := b.settingsFor(channel); len(settings.Prefixes) > 0 {
+		return settings.Prefixes
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.prefixes.forChannel(channel)
+}
+
+// isLanguageCode reports whether code looks like a language code such as
+// "en" or "pt-br".
+func isLanguageCode(code string) bool {
+	if len(code) < 2 || len(code) > 16 {
+		return false
+	}
+	for _, r := range strings.ToLower(code) {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// describe sums up a channel's settings for !channel.
+func (s ChannelSettings) describe(b *IRCBot) string {
+	disabled := "none"
+	if len(s.Disabled) > 0 {
+		disabled = strings.Join(s.Disabled, ", ")
+	}
+
+	language := s.Language
+	if language == "" {
+		language = "any"
+	}
+
+	return fmt.Sprintf("%s: greet %s, prefixes %s, language %s, disabled commands: %s", s.Channel, onOff(s.Greet), strings.Join(b.commandPrefixes(s.Channel), " "), language, disabled)
+}
+
+type channelCommand struct{ commandInfo }
+
+func (channelCommand) Run(ctx context.Context, req *Request) error {
+	channel := req.Args.String("channel")
+	if !req.Bot.isChannel(channel) {
+		return usageErrorf("%s isn't a channel", channel)
+	}
+
+	settings := req.Bot.settingsFor(channel)
+
+	if !req.Args.Has("setting") {
+		req.Reply(settings.describe(req.Bot))
+		return nil
+	}
+
+	setting := strings.ToLower(req.Args.String("setting"))
+	value := req.Args.String("value")
+	if value == "" {
+		return usageErrorf("missing value")
+	}
+
+	switch setting {
+	case "greet":
+		greet, ok := parseOnOff(value)
+		if !ok {
+			return usageErrorf("greet must be on or off")
+		}
+		settings.Greet = greet
+
+	case "prefix", "prefixes":
+		if strings.EqualFold(value, "default") {
+			settings.Prefixes = nil
+		} else {
+			settings.Prefixes = strings.Fields(value)
+		}
+
+	case "language":
+		switch {
+		case strings.EqualFold(value, "default") || strings.EqualFold(value, "any"):
+			settings.Language = ""
+		case isLanguageCode(value):
+			settings.Language = strings.ToLower(value)
+		default:
+			return usageErrorf("language must be a code like en or pt-br, or default")
+		}
+
+	default:
+		// Anything else is a command to allow or forbid in the channel.
+		cmd, ok := commands.lookup(setting)
+		if !ok {
+			return usageErrorf("setting must be greet, prefix, language, or the name of a command")
+		}
+		allowed, ok := parseOnOff(value)
+		if !ok {
+			return usageErrorf("%s must be on or off", cmd.Name())
+		}
+		if cmd.Name() == "channel" {
+			req.Reply("Sorry, turning off !channel would leave no way to turn it back on.")
+			return nil
+		}
+		settings.setAllowed(cmd.Name(), allowed)
+	}
+
+	if err := req.Bot.updateChannelSettings(ctx, settings); err != nil {
+		req.Reply(databaseError(err, "Error saving channel settings to database"))
+		return err
+	}
+
+	req.Reply(settings.describe(req.Bot))
+	return nil
+}
+
+func init() {
+	commands.register(channelCommand{commandInfo{
+		name: "channel",
+		signature: Signature{Args: []ArgSpec{
+			{Name: "channel"},
+			{Name: "setting", Optional: true},
+			{Name: "value", Optional: true, Rest: true},
+		}},
+		description: "Shows or changes what the bot does in a channel: '!channel #ops greet off', '!channel #ops prefix > .' (or 'default'), '!channel #ops language fr' to pick greetings in French, or '!channel #ops weather off' to turn a command off there.",
+		permission:  RoleAdmin,
+	}})
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scope says where a command may be used.
+type Scope int
+
+const (
+	// ScopeAnywhere is the default: channels and private queries.
+	ScopeAnywhere Scope = iota
+	ScopePublic
+	ScopePrivate
+)
+
+// allows reports whether a command with this scope can run in a private
+// query (private is true) or a channel.
+func (s Scope) allows(private bool) bool {
+	switch s {
+	case ScopePublic:
+		return !private
+	case ScopePrivate:
+		return private
+	default:
+		return true
+	}
+}
+
+// Command is a feature users can invoke with !name.
+type Command interface {
+	Name() string
+	Aliases() []string
+	// Signature declares the arguments the command takes, and Usage is
+	// the synopsis generated from it, e.g. "<location>".
+	Signature() Signature
+	Usage() string
+	Description() string
+	// Permission is the least role a user needs to run the command.
+	Permission() Role
+	Scope() Scope
+	Run(ctx context.Context, req *Request) error
+}
+
+// errUsage is returned by a command when it was invoked with the wrong
+// arguments, usually wrapped in a UsageError. The caller answers with the
+// command's help.
+var errUsage = errors.New("wrong arguments")
+
+// Request is a single invocation of a command.
+type Request struct {
+	Bot     *IRCBot
+	Message *Message
+	// Nick is who sent the command, and Target is where replies go: the
+	// channel it was sent in, or the sender for private messages.
+	Nick    string
+	Target  string
+	Private bool
+	// Account is the services account the sender is logged in to, as
+	// services told us, or "" if they aren't or we couldn't find out. Role
+	// is the sender's role, at least the command's Permission, and
+	// Settings are the sender's own settings.
+	Account  string
+	Role     Role
+	Settings UserSettings
+	// Name is the command name as typed, which may be an alias. Text is
+	// everything after it, and Args is Text parsed against the command's
+	// signature.
+	Name string
+	Text string
+	Args *Args
+}
+
+// Reply sends lines back to wherever the command came from.
+func (r *Request) Reply(lines ...string) {
+	for _, line := range lines {
+		r.Bot.sendMessage(r.Target, line)
+	}
+}
+
+// Prefix is the first command prefix where the command was sent, for
+// showing how to use commands there.
+func (r *Request) Prefix() string {
+	return r.Bot.commandPrefixes(r.Target)[0]
+}
+
+// Action replies with a CTCP ACTION, like a /me.
+func (r *Request) Action(text string) {
+	r.Bot.sendAction(r.Target, text)
+}
+
+// commandInfo holds the descriptive parts of a command and implements
+// everything in Command except Run, so a command type only needs to embed
+// it and add a Run method.
+type commandInfo struct {
+	name        string
+	aliases     []string
+	signature   Signature
+	description string
+	permission  Role
+	scope       Scope
+}
+
+func (c commandInfo) Name() string         { return c.name }
+func (c commandInfo) Aliases() []string    { return c.aliases }
+func (c commandInfo) Signature() Signature { return c.signature }
+func (c commandInfo) Usage() string        { return c.signature.Usage() }
+func (c commandInfo) Description() string  { return c.description }
+func (c commandInfo) Permission() Role     { return c.permission }
+func (c commandInfo) Scope() Scope         { return c.scope }
+
+// registry maps command names and aliases to commands.
+type registry struct {
+	byName map[string]Command
+}
+
+// commands is every command the bot knows. Features add themselves to it
+// with register from an init function.
+var commands = &registry{byName: make(map[string]Command)}
+
+// register adds cmd under its name and aliases. Registering the same name
+// twice is a programming error.
+func (r *registry) register(cmd Command) {
+	for _, name := range append([]string{cmd.Name()}, cmd.Aliases()...) {
+		name = strings.ToLower(name)
+		if _, exists := r.byName[name]; exists {
+			panic(fmt.Sprintf("command %q registered twice", name))
+		}
+		r.byName[name] = cmd
+	}
+}
+
+// lookup finds a command by name or alias.
+func (r *registry) lookup(name string) (Command, bool) {
+	cmd, ok := r.byName[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// list returns each command once, sorted by name.
+func (r *registry) list() []Command {
+	var list []Command
+	for name, cmd := range r.byName {
+		if name == cmd.Name() {
+			list = append(list, cmd)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// DEFAULT_COMMAND_PREFIX is used when COMMAND_PREFIXES isn't set.
+const DEFAULT_COMMAND_PREFIX = "!"
+
+// CommandPrefixes are the prefixes that mark a message as a command, e.g.
+// "!" in "!weather Sydney". Channels can have their own.
+type CommandPrefixes struct {
+	Default  []string
+	Channels map[string][]string
+}
+
+// parseCommandPrefixes reads a prefix spec of semicolon separated groups of
+// space separated prefixes. A group starting with "#channel=" only applies
+// to that channel, e.g. "! .;#ops=>" uses "!" or "." everywhere except
+// #ops, which uses ">".
+func parseCommandPrefixes(spec string) CommandPrefixes {
+	prefixes := CommandPrefixes{Channels: make(map[string][]string)}
+
+	for _, group := range strings.Split(spec, ";") {
+		channel, list, found := strings.Cut(group, "=")
+		if !found {
+			channel, list = "", group
+		}
+
+		if fields := strings.Fields(list); len(fields) > 0 {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				prefixes.Channels[strings.ToLower(channel)] = fields
+			} else {
+				prefixes.Default = fields
+			}
+		}
+	}
+
+	if len(prefixes.Default) == 0 {
+		prefixes.Default = []string{DEFAULT_COMMAND_PREFIX}
+	}
+	return prefixes
+}
+
+// forChannel returns the prefixes used in channel.
+func (p CommandPrefixes) forChannel(channel string) []string {
+	if prefixes, ok := p.Channels[strings.ToLower(channel)]; ok {
+		return prefixes
+	}
+	return p.Default
+}
+
+// stripAddress removes "nick:" or "nick," from the start of text, returning
+// false if text isn't addressed to nick.
+func stripAddress(text, nick string) (string, bool) {
+	if len(text) <= len(nick) || !strings.EqualFold(text[:len(nick)], nick) {
+		return "", false
+	}
+
+	rest := text[len(nick):]
+	if !strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, ",") {
+		return "", false
+	}
+	return strings.TrimSpace(rest[1:]), true
+}
+
+// parseCommand works out whether a PRIVMSG is a command and splits it into
+// the command name and its arguments. A command is a message starting with
+// one of the channel's prefixes, one addressed to the bot by nick
+// ("benbot: weather Sydney"), or anything at all sent in a private query.
+func (b *IRCBot) parseCommand(message *Message) (name, text string, ok bool) {
+	text = strings.TrimSpace(message.Trailing())
+	channel := message.Param(0)
+	private := b.isMe(channel)
+
+	if rest, addressed := stripAddress(text, b.currentNick()); addressed {
+		text = rest
+	} else {
+		prefixed := false
+		for _, prefix := range b.commandPrefixes(channel) {
+			if strings.HasPrefix(text, prefix) {
+				text = text[len(prefix):]
+				prefixed = true
+				break
+			}
+		}
+
+		if !prefixed && !private {
+			return "", "", false
+		}
+	}
+
+	name, text, _ = strings.Cut(text, " ")
+	if name == "" {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(text), true
+}
+
+// handleCommandMessage looks for a command in a PRIVMSG and hands it to the
+// worker pool, which checks the sender is allowed to run it.
+func (b *IRCBot) handleCommandMessage(message *Message) {
+	name, text, ok := b.parseCommand(message)
+	if !ok {
+		return
+	}
+
+	cmd, ok := commands.lookup(name)
+	if !ok {
+		return
+	}
+
+	// Answer in the channel the command came from, or privately if it was
+	// sent to us directly.
+	req := &Request{
+		Bot:     b,
+		Message: message,
+		Nick:    message.Nick(),
+		Target:  b.replyTarget(message),
+		Private: b.isMe(message.Param(0)),
+		Name:    name,
+		Text:    text,
+	}
+
+	if !b.dispatch(func(ctx context.Context) {
+		// Looking up roles hits the database, so it happens here rather
+		// than on the read loop. Nothing is said to anyone until we know
+		// they're allowed the command, so strangers can't make us talk.
+		account, accountErr := b.accountFor(ctx, message)
+		if accountErr != nil {
+			fmt.Println("Error checking account:", accountErr)
+		}
+
+		role, err := b.roleFor(ctx, message.Prefix, account)
+		if err != nil {
+			fmt.Println("Error looking up role:", err)
+		}
+		if role < cmd.Permission() && errors.Is(err, errDatabaseDown) && b.databaseDownLimit.allow(req.Nick, DATABASE_DOWN_COOLDOWN) {
+			// They might have a role we can't see right now, so explain,
+			// but only now and then.
+			fmt.Println("Database down, so can't check the role of:", message.Prefix.String())
+			req.Reply(databaseError(err, ""))
+			return
+		}
+		if role < cmd.Permission() {
+			fmt.Println("User not trusted:", message.Prefix.String())
+			return
+		}
+
+		req.Account, req.Role = account, role
+
+		if !cmd.Scope().allows(req.Private) {
+			if req.Private {
+				req.Reply(fmt.Sprintf("Sorry, %s only works in a channel.", cmd.Name()))
+			} else {
+				req.Reply(fmt.Sprintf("Sorry, %s only works in a private message. Try /msg %s %s", cmd.Name(), b.currentNick(), cmd.Name()))
+			}
+			return
+		}
+
+		if !req.Private && !b.settingsFor(req.Target).allows(cmd.Name()) {
+			req.Reply(fmt.Sprintf("Sorry, %s is turned off in %s.", cmd.Name(), req.Target))
+			return
+		}
+
+		req.Settings, err = getUserSettings(ctx, req.Nick, account)
+		if err != nil {
+			fmt.Println("Error looking up settings:", err)
+		}
+		if setting, ok := settingForCommand(cmd.Name()); ok {
+			allowed, err := commandAllowed(ctx, message.Prefix, account, setting)
+			if err != nil {
+				fmt.Println("Error checking", setting.name, "setting:", err)
+			}
+			if accountErr != nil {
+				// It may have been taken away from their account, and
+				// we can't tell who they are.
+				allowed = false
+			}
+			*setting.field(&req.Settings) = allowed
+			if !allowed {
+				req.Reply(fmt.Sprintf("Sorry, %s is turned off for you.", cmd.Name()))
+				return
+			}
+		}
+
+		b.runCommand(ctx, cmd, req)
+	}) {
+		// We don't know who they are yet, so only say so if anyone could
+		// have run it.
+		if cmd.Permission() == RoleEveryone {
+			req.Reply("Sorry, I'm a bit busy right now. Try again in a moment.")
+		} else {
+			fmt.Println("Too busy to run", cmd.Name(), "for", message.Prefix.String())
+		}
+	}
+}
+
+// runCommand parses req's arguments and runs cmd, answering with what went
+// wrong and the command's usage if it was used wrongly. It's called from the
+// worker pool.
+func (b *IRCBot) runCommand(ctx context.Context, cmd Command, req *Request) {
+	words, err := tokenize(req.Text)
+	if err == nil {
+		req.Args, err = cmd.Signature().parse(words)
+	}
+	if err == nil {
+		err = cmd.Run(ctx, req)
+	}
+
+	if errors.Is(err, errUsage) {
+		var usageErr *UsageError
+		if errors.As(err, &usageErr) {
+			req.Reply("Sorry, " + usageErr.Reason + ".")
+		}
+		resp, _ := getHelp(cmd.Name(), req.Prefix())
+		req.Reply(resp...)
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("Error running %s: %s\n", cmd.Name(), err)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DEFAULT_CONFIG_FILE is read when -config isn't given. It's fine for it
+// not to exist, as everything can be set with environment variables.
+const DEFAULT_CONFIG_FILE = "config.yaml"
+
+// Config is everything the bot can be configured with. It's read from a
+// YAML file, then environment variables override it; see envOverrides.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Bot      BotConfig      `yaml:"bot"`
+	Database DatabaseConfig `yaml:"database"`
+	Weather  WeatherConfig  `yaml:"weather"`
+}
+
+// ServerConfig is how to connect and authenticate. Changing it needs a
+// restart.
+type ServerConfig struct {
+	Host           string `yaml:"host"`
+	Port           string `yaml:"port"`
+	TLS            bool   `yaml:"tls"`
+	TLSCAFile      string `yaml:"tls_ca_file"`
+	TLSFingerprint string `yaml:"tls_fingerprint"`
+	TLSClientCert  string `yaml:"tls_client_cert"`
+	TLSClientKey   string `yaml:"tls_client_key"`
+	// SASLMechanism is PLAIN, EXTERNAL, or empty to skip SASL.
+	SASLMechanism string `yaml:"sasl_mechanism"`
+	SASLUsername  string `yaml:"sasl_username"`
+	SASLPassword  string `yaml:"sasl_password"`
+	// NickServPassword is used to identify with NickServ after
+	// registration, when SASL isn't in use.
+	NickServPassword string `yaml:"nickserv_password"`
+	// FloodInterval and FloodBurst are the send queue's token bucket: a
+	// line every FloodInterval, after a burst of up to FloodBurst lines.
+	FloodInterval time.Duration `yaml:"flood_interval"`
+	FloodBurst    int           `yaml:"flood_burst"`
+}
+
+// BotConfig is who the bot is and what it does. Everything but the nicks
+// can be reloaded with SIGHUP.
+type BotConfig struct {
+	Nick     string   `yaml:"nick"`
+	AltNicks []string `yaml:"alt_nicks"`
+	// Channels maps each channel to its key, or "" if it has none.
+	Channels map[string]string `yaml:"channels"`
+	// CommandPrefixes is a spec for parseCommandPrefixes, e.g. "! .;#ops=>".
+	CommandPrefixes string `yaml:"command_prefixes"`
+	// Owners are services accounts or nick!user@host masks that always
+	// have every permission.
+	Owners []string `yaml:"owners"`
+	// AccountCheck is how we ask who someone is logged in as when the
+	// server doesn't tell us: "WHOIS", or "ACC" or "STATUS" to ask NickServ
+	// (Atheme and Anope respectively).
+	AccountCheck string `yaml:"account_check"`
+}
+
+// DatabaseConfig is where the bot keeps its data. Changing it needs a
+// restart.
+type DatabaseConfig struct {
+	// Driver is postgres, sqlite or memory. Path is the SQLite database
+	// file, and the rest are for Postgres.
+	Driver   string `yaml:"driver"`
+	Path     string `yaml:"path"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Name     string `yaml:"name"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime tune the connection
+	// pool. SQLite always uses a single connection.
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// QueryTimeout bounds every call to the database.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// ConnectRetries is how many more times to try reaching the database
+	// at startup before carrying on without it.
+	ConnectRetries int `yaml:"connect_retries"`
+	// AutoMigrate applies migrations at startup. Turn it off when the bot's
+	// database user doesn't own the tables, and run "migrate up" as the
+	// owner instead.
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+// WeatherConfig is where !weather gets its forecasts from.
+type WeatherConfig struct {
+	FTPServer   string `yaml:"ftp_server"`
+	FTPUser     string `yaml:"ftp_user"`
+	FTPPassword string `yaml:"ftp_password"`
+	FTPFilePath string `yaml:"ftp_file_path"`
+	FTPFileName string `yaml:"ftp_file_name"`
+}
+
+// defaultConfig is the configuration before the file and environment are
+// applied.
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:          "irc.libera.chat",
+			Port:          "6697",
+			TLS:           true,
+			SASLMechanism: "PLAIN",
+			FloodInterval: FLOOD_INTERVAL,
+			FloodBurst:    FLOOD_BURST,
+		},
+		Bot: BotConfig{
+			Nick:         "benbot",
+			Channels:     make(map[string]string),
+			AccountCheck: "WHOIS",
+		},
+		Database: DatabaseConfig{
+			Driver:          "postgres",
+			Path:            "benevolent.db",
+			MaxOpenConns:    10,
+			MaxIdleConns:    2,
+			ConnMaxLifetime: 30 * time.Minute,
+			QueryTimeout:    5 * time.Second,
+			ConnectRetries:  3,
+			AutoMigrate:     true,
+		},
+	}
+}
+
+// envOverride sets part of the config from an environment variable.
+type envOverride struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = splitList(value)
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration like 5s or 1m")
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// envOverrides are the environment variables that override the config file.
+// CHANNEL and CHANNEL_PASSWORD are handled separately, as they only make
+// sense together.
+var envOverrides = []envOverride{
+	{"IRC_HOST", setString(func(c *Config) *string { return &c.Server.Host })},
+	{"IRC_PORT", setString(func(c *Config) *string { return &c.Server.Port })},
+	{"IRC_TLS", setBool(func(c *Config) *bool { return &c.Server.TLS })},
+	{"TLS_CA_FILE", setString(func(c *Config) *string { return &c.Server.TLSCAFile })},
+	{"TLS_FINGERPRINT", setString(func(c *Config) *string { return &c.Server.TLSFingerprint })},
+	{"TLS_CLIENT_CERT", setString(func(c *Config) *string { return &c.Server.TLSClientCert })},
+	{"TLS_CLIENT_KEY", setString(func(c *Config) *string { return &c.Server.TLSClientKey })},
+	{"SASL_MECHANISM", setString(func(c *Config) *string { return &c.Server.SASLMechanism })},
+	// USE_NICKSERV turns SASL off, since an empty SASL_MECHANISM can't
+	// be told apart from an unset one.
+	{"USE_NICKSERV", func(c *Config, value string) error {
+		useNickServ, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		if useNickServ {
+			c.Server.SASLMechanism = ""
+		}
+		return nil
+	}},
+	{"SASL_USERNAME", setString(func(c *Config) *string { return &c.Server.SASLUsername })},
+	{"SASL_PASSWORD", setString(func(c *Config) *string { return &c.Server.SASLPassword })},
+	{"NICKSERV_PASSWORD", setString(func(c *Config) *string { return &c.Server.NickServPassword })},
+	{"FLOOD_INTERVAL", setDuration(func(c *Config) *time.Duration { return &c.Server.FloodInterval })},
+	{"FLOOD_BURST", setInt(func(c *Config) *int { return &c.Server.FloodBurst })},
+	{"BOT_NICK", setString(func(c *Config) *string { return &c.Bot.Nick })},
+	{"ALT_NICKS", setList(func(c *Config) *[]string { return &c.Bot.AltNicks })},
+	{"COMMAND_PREFIXES", setString(func(c *Config) *string { return &c.Bot.CommandPrefixes })},
+	{"BOT_OWNERS", setList(func(c *Config) *[]string { return &c.Bot.Owners })},
+	{"ACCOUNT_CHECK", setString(func(c *Config) *string { return &c.Bot.AccountCheck })},
+	{"DB_DRIVER", setString(func(c *Config) *string { return &c.Database.Driver })},
+	{"DB_PATH", setString(func(c *Config) *string { return &c.Database.Path })},
+	{"DB_HOST", setString(func(c *Config) *string { return &c.Database.Host })},
+	{"DB_PORT", setString(func(c *Config) *string { return &c.Database.Port })},
+	{"DB_NAME", setString(func(c *Config) *string { return &c.Database.Name })},
+	{"DB_USER", setString(func(c *Config) *string { return &c.Database.User })},
+	{"DB_PASSWORD", setString(func(c *Config) *string { return &c.Database.Password })},
+	{"DB_MAX_OPEN_CONNS", setInt(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", setInt(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", setDuration(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"DB_QUERY_TIMEOUT", setDuration(func(c *Config) *time.Duration { return &c.Database.QueryTimeout })},
+	{"DB_CONNECT_RETRIES", setInt(func(c *Config) *int { return &c.Database.ConnectRetries })},
+	{"DB_AUTO_MIGRATE", setBool(func(c *Config) *bool { return &c.Database.AutoMigrate })},
+	{"FTP_SERVER", setString(func(c *Config) *string { return &c.Weather.FTPServer })},
+	{"FTP_USER", setString(func(c *Config) *string { return &c.Weather.FTPUser })},
+	{"FTP_PASSWORD", setString(func(c *Config) *string { return &c.Weather.FTPPassword })},
+	{"FTP_FILE_PATH", setString(func(c *Config) *string { return &c.Weather.FTPFilePath })},
+	{"FTP_FILE_NAME", setString(func(c *Config) *string { return &c.Weather.FTPFileName })},
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadConfig reads the config file at path, applies environment overrides
+// and validates the result, reporting every problem at once. A missing file
+// is only an error if required is set, i.e. -config was given.
+func loadConfig(path string, required bool) (*Config, error) {
+	return readConfig(path, required, (*Config).validate)
+}
+
+// readConfig is loadConfig with the validation passed in, for when only
+// part of the config is needed.
+func readConfig(path string, required bool, validate func(*Config) []error) (*Config, error) {
+	config := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !required:
+		fmt.Println("No config file at", path+", using environment variables")
+	default:
+		return nil, err
+	}
+
+	var errs []error
+
+	for _, override := range envOverrides {
+		if value, ok := os.LookupEnv(override.name); ok && value != "" {
+			if err := override.set(config, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", override.name, err))
+			}
+		}
+	}
+
+	// CHANNEL and CHANNEL_PASSWORD take comma separated lists, the same
+	// way JOIN does: "#one,#two" with keys "key1,key2".
+	if channels := os.Getenv("CHANNEL"); channels != "" {
+		config.Bot.Channels = make(map[string]string)
+		keys := strings.Split(os.Getenv("CHANNEL_PASSWORD"), ",")
+		for i, channel := range strings.Split(channels, ",") {
+			if channel = strings.TrimSpace(channel); channel == "" {
+				continue
+			}
+			config.Bot.Channels[channel] = ""
+			if i < len(keys) {
+				config.Bot.Channels[channel] = strings.TrimSpace(keys[i])
+			}
+		}
+	}
+
+	if len(config.Bot.AltNicks) == 0 {
+		config.Bot.AltNicks = []string{config.Bot.Nick + "_", config.Bot.Nick + "__"}
+	}
+	if config.Server.SASLUsername == "" {
+		config.Server.SASLUsername = config.Bot.Nick
+	}
+	config.Server.SASLMechanism = strings.ToUpper(config.Server.SASLMechanism)
+	config.Bot.AccountCheck = strings.ToUpper(config.Bot.AccountCheck)
+
+	errs = append(errs, validate(config)...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return config, nil
+}
+
+// validate checks the config, returning everything that's wrong with it.
+func (c *Config) validate() []error {
+	var errs []error
+	missing := func(name, env string) {
+		errs = append(errs, fmt.Errorf("%s is not set (or %s)", name, env))
+	}
+
+	if c.Server.Host == "" {
+		missing("server.host", "IRC_HOST")
+	}
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %q is not a port number", c.Server.Port))
+	}
+
+	switch c.Server.SASLMechanism {
+	case "":
+	case "PLAIN":
+		if c.Server.SASLPassword == "" && c.Server.NickServPassword != "" {
+			errs = append(errs, errors.New("server.sasl_password is not set (or SASL_PASSWORD); to identify with NickServ instead, set server.sasl_mechanism to \"\" (or USE_NICKSERV=true)"))
+		} else if c.Server.SASLPassword == "" {
+			missing("server.sasl_password", "SASL_PASSWORD")
+		}
+	case "EXTERNAL":
+		if !c.Server.TLS {
+			errs = append(errs, errors.New("SASL EXTERNAL needs a TLS client certificate, but server.tls is false"))
+		}
+		if c.Server.TLSClientCert == "" {
+			missing("server.tls_client_cert", "TLS_CLIENT_CERT")
+		}
+	default:
+		errs = append(errs, fmt.Errorf("server.sasl_mechanism %q must be PLAIN, EXTERNAL or empty", c.Server.SASLMechanism))
+	}
+
+	if (c.Server.TLSClientCert == "") != (c.Server.TLSClientKey == "") {
+		errs = append(errs, errors.New("server.tls_client_cert and server.tls_client_key must be set together"))
+	}
+	if c.Server.FloodInterval <= 0 {
+		errs = append(errs, errors.New("server.flood_interval must be more than 0"))
+	}
+	if c.Server.FloodBurst < 1 {
+		errs = append(errs, errors.New("server.flood_burst must be at least 1"))
+	}
+	// Load the CA bundle and client certificate now, so a bad path stops
+	// the bot at startup instead of failing every reconnect.
+	if tlsConfig := c.tlsConfig(); tlsConfig != nil {
+		if _, err := tlsConfig.build(c.Server.Host); err != nil {
+			errs = append(errs, fmt.Errorf("server TLS settings: %w", err))
+		}
+	}
+
+	if c.Bot.Nick == "" {
+		missing("bot.nick", "BOT_NICK")
+	}
+	if len(c.Bot.Channels) == 0 {
+		missing("bot.channels", "CHANNEL")
+	}
+	for channel := range c.Bot.Channels {
+		if channel == "" || !strings.ContainsAny(channel[:1], "#&+!") {
+			errs = append(errs, fmt.Errorf("bot.channels: %q is not a channel", channel))
+		}
+	}
+	switch c.Bot.AccountCheck {
+	case "WHOIS", "ACC", "STATUS":
+	default:
+		errs = append(errs, fmt.Errorf("bot.account_check %q must be WHOIS, ACC or STATUS", c.Bot.AccountCheck))
+	}
+
+	return append(errs, c.Database.validate()...)
+}
+
+// validate checks the database settings, returning everything that's wrong
+// with them.
+func (d DatabaseConfig) validate() []error {
+	var errs []error
+	missing := func(name, env string) {
+		errs = append(errs, fmt.Errorf("%s is not set (or %s)", name, env))
+	}
+
+	switch d.Driver {
+	case "postgres":
+		if d.Host == "" {
+			missing("database.host", "DB_HOST")
+		}
+		if d.Port == "" {
+			missing("database.port", "DB_PORT")
+		}
+		if d.Name == "" {
+			missing("database.name", "DB_NAME")
+		}
+		if d.User == "" {
+			missing("database.user", "DB_USER")
+		}
+		if d.Password == "" {
+			missing("database.password", "DB_PASSWORD")
+		}
+	case "sqlite":
+		if d.Path == "" {
+			missing("database.path", "DB_PATH")
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q must be postgres, sqlite or memory", d.Driver))
+	}
+
+	if d.MaxOpenConns < 1 {
+		errs = append(errs, errors.New("database.max_open_conns must be at least 1"))
+	}
+	if d.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_idle_conns can't be negative"))
+	}
+	if d.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database.conn_max_lifetime can't be negative"))
+	}
+	if d.QueryTimeout <= 0 {
+		errs = append(errs, errors.New("database.query_timeout must be more than 0"))
+	}
+	if d.ConnectRetries < 0 {
+		errs = append(errs, errors.New("database.connect_retries can't be negative"))
+	}
+
+	return errs
+}
+
+// nicks returns the primary nick followed by the alternates.
+func (c *Config) nicks() []string {
+	return append([]string{c.Bot.Nick}, c.Bot.AltNicks...)
+}
+
+// tlsConfig returns the TLS settings, or nil if TLS is off.
+func (c *Config) tlsConfig() *TLSConfig {
+	if !c.Server.TLS {
+		return nil
+	}
+	return &TLSConfig{
+		CAFile:      c.Server.TLSCAFile,
+		Fingerprint: c.Server.TLSFingerprint,
+		CertFile:    c.Server.TLSClientCert,
+		KeyFile:     c.Server.TLSClientKey,
+	}
+}
+
+// saslConfig returns the SASL settings, or nil if SASL is off.
+func (c *Config) saslConfig() *SASLConfig {
+	if c.Server.SASLMechanism == "" {
+		return nil
+	}
+	return &SASLConfig{
+		Mechanism: c.Server.SASLMechanism,
+		Username:  c.Server.SASLUsername,
+		Password:  c.Server.SASLPassword,
+	}
+}
+
+// reload returns next with the settings that can't change without
+// reconnecting kept as they are in c, and says which of those differ.
+func (c *Config) reload(next *Config) (*Config, []string) {
+	var ignored []string
+	if next.Server != c.Server {
+		ignored = append(ignored, "server")
+	}
+	if next.Bot.Nick != c.Bot.Nick || strings.Join(next.Bot.AltNicks, ",") != strings.Join(c.Bot.AltNicks, ",") {
+		ignored = append(ignored, "bot.nick", "bot.alt_nicks")
+	}
+	if next.Database != c.Database {
+		ignored = append(ignored, "database")
+	}
+
+	merged := *next
+	merged.Server = c.Server
+	merged.Bot.Nick = c.Bot.Nick
+	merged.Bot.AltNicks = c.Bot.AltNicks
+	merged.Database = c.Database
+	return &merged, ignored
+}
+
+// channelChanges compares two channel lists, returning the channels to join
+// and to leave.
+func channelChanges(old, next map[string]string) (join, part []string) {
+	for channel := range next {
+		if _, ok := old[channel]; !ok {
+			join = append(join, channel)
+		}
+	}
+	for channel := range old {
+		if _, ok := next[channel]; !ok {
+			part = append(part, channel)
+		}
+	}
+
+	sort.Strings(join)
+	sort.Strings(part)
+	return join, part
+}
+
+// watchConfig reloads the config file on SIGHUP, sending each new config
+// that's valid. Invalid ones are reported and ignored.
+func watchConfig(path string, required bool) <-chan *Config {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	reloads := make(chan *Config)
+	go func() {
+		for range hangups {
+			fmt.Println("Reloading configuration from", path)
+
+			config, err := loadConfig(path, required)
+			if err != nil {
+				fmt.Println("Keeping the current configuration:", err)
+				continue
+			}
+			reloads <- config
+		}
+	}()
+	return reloads
+}
+
+// applyConfig starts using the reloadable settings in config.
+func (b *IRCBot) applyConfig(config *Config) {
+	owners := parseOwners(strings.Join(config.Bot.Owners, ","))
+	prefixes := parseCommandPrefixes(config.Bot.CommandPrefixes)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	b.owners = owners
+	b.prefixes = prefixes
+}
+
+// currentConfig returns the configuration in use.
+func (b *IRCBot) currentConfig() *Config {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.config
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	// RECONNECT_MIN_DELAY and RECONNECT_MAX_DELAY bound the exponential
+	// backoff between reconnection attempts.
+	RECONNECT_MIN_DELAY = 5 * time.Second
+	RECONNECT_MAX_DELAY = 5 * time.Minute
+	// STABLE_CONNECTION is how long a connection has to last before the
+	// backoff is reset.
+	STABLE_CONNECTION = 2 * time.Minute
+	// PING_INTERVAL is how often we ping an otherwise quiet server, and
+	// READ_TIMEOUT is how long we wait for any line before giving up on it.
+	PING_INTERVAL = 2 * time.Minute
+	READ_TIMEOUT  = 5 * time.Minute
+)
+
+// backoffDelay returns how long to wait before the given reconnect attempt,
+// doubling from RECONNECT_MIN_DELAY up to RECONNECT_MAX_DELAY, with up to a
+// quarter of the delay added as jitter.
+func backoffDelay(attempt int) time.Duration {
+	delay := RECONNECT_MIN_DELAY
+	for i := 0; i < attempt && delay < RECONNECT_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	if delay > RECONNECT_MAX_DELAY {
+		delay = RECONNECT_MAX_DELAY
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay/4)+1))
+}
+
+// keepAlive pings the server every PING_INTERVAL until done is closed, so a
+// dead connection trips the read deadline in receiveMessages.
+func (b *IRCBot) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			b.sendRaw(fmt.Sprintf("PING :%d", time.Now().Unix()))
+		}
+	}
+}
+
+// trackChannel records that the bot joined or left a channel, so the
+// supervisor can rejoin it after a reconnect.
+func (b *IRCBot) trackChannel(channel string, joined bool) {
+	channel = strings.ToLower(channel)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if joined {
+		b.joined[channel] = b.keys[channel]
+	} else {
+		delete(b.joined, channel)
+	}
+}
+
+// Channels returns the channels the bot is currently in, with their keys.
+func (b *IRCBot) Channels() map[string]string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	channels := make(map[string]string, len(b.joined))
+	for channel, key := range b.joined {
+		channels[channel] = key
+	}
+	return channels
+}
+
+// superviseBot keeps the bot connected forever. Each time the connection
+// drops it reconnects with exponential backoff, then rejoins every channel
+// it was in. A SASL failure is fatal rather than retried. New configs from
+// reloads take effect straight away, except for the connection settings,
+// which wait for a restart.
+func superviseBot(config *Config, reloads <-chan *Config) {
+	attempt := 0
+	channels := config.Bot.Channels
+
+	// wait sleeps for delay, applying any reloads that arrive meanwhile.
+	wait := func(delay time.Duration) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				return
+			case next := <-reloads:
+				config = reloadConfig(nil, config, next)
+				channels = config.Bot.Channels
+			}
+		}
+	}
+
+	for {
+		bot, err := connectBot(config)
+		if err != nil {
+			if errors.Is(err, errSASLFailed) {
+				log.Fatal("Error creating IRC bot: ", err)
+			}
+
+			delay := backoffDelay(attempt)
+			fmt.Printf("Error connecting: %s. Retrying in %s\n", err, delay.Round(time.Second))
+			attempt++
+			wait(delay)
+			continue
+		}
+
+		connected := time.Now()
+		done := make(chan struct{})
+
+		// Start a goroutine to handle incoming messages
+		go func() {
+			bot.receiveMessages()
+			close(done)
+		}()
+		go bot.keepAlive(done)
+
+		// We're registered by now, so identify before showing up anywhere.
+		bot.identify(done)
+		bot.reclaimNick()
+		for channel, key := range channels {
+			bot.joinChannel(channel, key)
+		}
+
+	running:
+		for {
+			select {
+			case <-done:
+				break running
+			case next := <-reloads:
+				config = reloadConfig(bot, config, next)
+			case <-databaseRecovered():
+				// Channel settings couldn't be loaded while the
+				// database was away.
+				ctx, cancel := context.WithTimeout(context.Background(), COMMAND_TIMEOUT)
+				if err := bot.loadChannelSettings(ctx); err != nil {
+					fmt.Println("Error loading channel settings:", err)
+				}
+				cancel()
+			}
+		}
+		bot.close()
+
+		// Remember where we were, unless we never got as far as joining.
+		if rejoin := bot.Channels(); len(rejoin) > 0 {
+			channels = rejoin
+		}
+
+		if time.Since(connected) > STABLE_CONNECTION {
+			attempt = 0
+		}
+
+		delay := backoffDelay(attempt)
+		fmt.Printf("Disconnected from server. Reconnecting in %s\n", delay.Round(time.Second))
+		attempt++
+		wait(delay)
+	}
+}
+
+// reloadConfig switches from config to next, joining and leaving channels
+// on bot if it's connected, and returns the config now in use.
+func reloadConfig(bot *IRCBot, config, next *Config) *Config {
+	next, ignored := config.reload(next)
+	if len(ignored) > 0 {
+		fmt.Println("Restart to apply changes to:", strings.Join(ignored, ", "))
+	}
+
+	if bot != nil {
+		bot.applyConfig(next)
+
+		join, part := channelChanges(config.Bot.Channels, next.Bot.Channels)
+		for _, channel := range join {
+			bot.joinChannel(channel, next.Bot.Channels[channel])
+		}
+		for _, channel := range part {
+			bot.sendRaw("PART " + channel)
+		}
+	}
+
+	fmt.Println("Configuration reloaded")
+	return next
+}
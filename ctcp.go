@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BOT_VERSION is our answer to CTCP VERSION.
+	BOT_VERSION = "benevolent IRC bot - https://github.com/killerbeebatteries/benevolent"
+	// CTCP_COOLDOWN is the least time between CTCP replies, so a CTCP flood
+	// can't get us kicked for flooding in turn.
+	CTCP_COOLDOWN = 2 * time.Second
+)
+
+// ctcpDelim marks the start and end of a CTCP message.
+const ctcpDelim = "\x01"
+
+// ctcpCommands are the CTCP requests we answer, for CLIENTINFO.
+var ctcpCommands = []string{"ACTION", "CLIENTINFO", "PING", "TIME", "VERSION"}
+
+// parseCTCP splits a CTCP message into its command and parameters. ok is
+// false if text isn't CTCP at all. The closing delimiter is optional, as
+// some clients leave it off.
+func parseCTCP(text string) (command, params string, ok bool) {
+	if !strings.HasPrefix(text, ctcpDelim) {
+		return "", "", false
+	}
+
+	text = strings.TrimSuffix(text[1:], ctcpDelim)
+	command, params, _ = strings.Cut(text, " ")
+	return strings.ToUpper(command), params, command != ""
+}
+
+// encodeCTCP builds a CTCP message.
+func encodeCTCP(command, params string) string {
+	if params == "" {
+		return ctcpDelim + command + ctcpDelim
+	}
+	return ctcpDelim + command + " " + params + ctcpDelim
+}
+
+// ctcpLimiter spaces out CTCP replies by at least CTCP_COOLDOWN.
+type ctcpLimiter struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (l *ctcpLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.last) < CTCP_COOLDOWN {
+		return false
+	}
+	l.last = time.Now()
+	return true
+}
+
+// handleCTCP answers a CTCP request sent to us in a PRIVMSG. Replies go
+// back as a NOTICE, as the spec requires, to the nick that asked.
+func (b *IRCBot) handleCTCP(message *Message, command, params string) {
+	if command == "ACTION" {
+		// A /me, not a request, so there's nothing to answer.
+		return
+	}
+
+	var reply string
+	switch command {
+	case "VERSION":
+		reply = BOT_VERSION
+	case "PING":
+		reply = params
+	case "TIME":
+		reply = time.Now().Format(time.RFC1123Z)
+	case "CLIENTINFO":
+		reply = strings.Join(ctcpCommands, " ")
+	default:
+		fmt.Println("Ignoring unknown CTCP", command, "from", message.Nick())
+		return
+	}
+
+	if !b.ctcpLimit.allow() {
+		fmt.Println("Not answering CTCP", command, "from", message.Nick(), "- too many requests")
+		return
+	}
+
+	b.sendCTCPReply(message.Nick(), command, reply)
+}
+
+// sendCTCPReply sends a CTCP response in a NOTICE.
+func (b *IRCBot) sendCTCPReply(target, command, params string) {
+	b.queue.push(target, fmt.Sprintf("NOTICE %s :%s", target, encodeCTCP(command, params)))
+}
+
+// sendAction sends a CTCP ACTION, which clients show like a /me.
+func (b *IRCBot) sendAction(target, action string) {
+	budget := b.messageBudget("PRIVMSG", target) - len(encodeCTCP("ACTION", " "))
+	for _, line := range splitMessage(action, budget) {
+		b.queue.push(target, fmt.Sprintf("PRIVMSG %s :%s", target, encodeCTCP("ACTION", line)))
+	}
+}
@@ -1,40 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 )
 
 type Greeting struct {
 	ID        int
 	FirstWord string
 	Body      string
+	// Language is the greeting's language code, or "" if it isn't tied
+	// to one.
+	Language string
 }
 
-func getGreetings(name string) (string, error) {
-	var greetings []Greeting
+// greetingsIn picks the greetings to use in language: those in it, or the
+// ones not tied to a language if there are none. Every greeting suits a
+// channel with no language set.
+func greetingsIn(greetings []Greeting, language string) []Greeting {
+	if language == "" {
+		return greetings
+	}
+
+	var matching, untagged []Greeting
+	for _, greeting := range greetings {
+		switch {
+		case strings.EqualFold(greeting.Language, language):
+			matching = append(matching, greeting)
+		case greeting.Language == "":
+			untagged = append(untagged, greeting)
+		}
+	}
+
+	if len(matching) > 0 {
+		return matching
+	}
+	return untagged
+}
+
+// getGreetings returns a random greeting for name in language, or nothing
+// if they've turned greetings off.
+func getGreetings(ctx context.Context, name string, settings UserSettings, language string) (string, error) {
 	var greetingCount int
 	var randomGreeting Greeting
 
+	if !settings.Greet {
+		return "", nil
+	}
+
 	defaultGreeting := fmt.Sprintf("Hello %s", name)
 
-	rows, err := DB.Query("SELECT id, first_word, body FROM greetings")
+	greetings, err := DB.Greetings(ctx)
 
 	if err != nil {
-		return defaultGreeting, err
+		return "", err
 	}
 
-	defer rows.Close()
-
-	for rows.Next() {
-		var greeting Greeting
-		err := rows.Scan(&greeting.ID, &greeting.FirstWord, &greeting.Body)
-
-		if err != nil {
-			return defaultGreeting, err
-		}
-		greetings = append(greetings, greeting)
-	}
+	greetings = greetingsIn(greetings, language)
 
 	if len(greetings) == 0 {
 		return defaultGreeting, nil
@@ -1,50 +1,70 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
 
-func getHelp(feature string) ([]string, error) {
+// getHelp describes feature, or lists every feature if it's empty, showing
+// commands with prefix. The text comes from the command registry, so it
+// can't drift from what the bot actually does.
+func getHelp(feature, prefix string) ([]string, error) {
 
 	var help []string
 
-	switch feature {
-
-	case "time":
-		help = append(help, "Usage: !time")
-		help = append(help, "Description: Will return the current time.")
-
-	case "ping":
-		help = append(help, "Usage: !ping")
-		help = append(help, "Description: Will return 'pong'.")
-
-	case "hello":
-		help = append(help, "Usage: !hello")
-		help = append(help, "Description: Say hello.")
-
-	case "relay_url":
-		help = append(help, "Usage: !relay_url <user> <url> <description>")
-		help = append(help, "Description: Will post your message to the channel the next time the target user is active.")
-
-	case "weather":
-		help = append(help, "Usage: !weather <location>")
-		help = append(help, "Description: Will return the current weather for the specified location.")
-	default:
-    if feature != "" {
-      help = append(help, fmt.Sprintf("Sorry, feature %s was not found.", feature))
-    }
-		help = append(help, "Available features are: time, ping, hello, relay_url, weather")
-		help = append(help, "Usage: !help <feature>")
+	if cmd, ok := commands.lookup(strings.TrimPrefix(feature, prefix)); ok && feature != "" {
+		help = append(help, strings.TrimSpace("Usage: "+prefix+cmd.Name()+" "+cmd.Usage()))
+		help = append(help, "Description: "+cmd.Description())
+		switch cmd.Scope() {
+		case ScopePublic:
+			help = append(help, "Only works in a channel.")
+		case ScopePrivate:
+			help = append(help, "Only works in a private message.")
+		}
+		if len(cmd.Aliases()) > 0 {
+			help = append(help, "Also known as: "+strings.Join(cmd.Aliases(), ", "))
+		}
+		return help, nil
 	}
 
+	if feature != "" {
+		help = append(help, fmt.Sprintf("Sorry, feature %s was not found.", feature))
+	}
+
+	var names []string
+	for _, cmd := range commands.list() {
+		names = append(names, cmd.Name())
+	}
+	help = append(help, "Available features are: "+strings.Join(names, ", "))
+	help = append(help, "Usage: "+prefix+"help <feature>")
+
 	return help, nil
 }
 
-func getUserFromMessage(message string) string {
-	messageSlice := strings.Split(message, " ")
+// getUserFromMessage returns the nick that sent the message.
+func getUserFromMessage(message *Message) string {
+	return message.Nick()
+}
+
+type helpCommand struct{ commandInfo }
+
+func (helpCommand) Run(ctx context.Context, req *Request) error {
+	resp, err := getHelp(req.Args.String("feature"), req.Prefix())
+	if err != nil {
+		return err
+	}
+	req.Reply(resp...)
+	return nil
+}
 
-	user := strings.Split(messageSlice[0], "!")[0]
-	user = strings.TrimLeft(user, ":")
-	return user
+func init() {
+	commands.register(helpCommand{commandInfo{
+		name: "help",
+		signature: Signature{Args: []ArgSpec{
+			{Name: "feature", Optional: true},
+		}},
+		description: "Lists the available features, or explains how to use one.",
+		permission:  RoleTrusted,
+	}})
 }
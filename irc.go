@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prefix is the source of an IRC message, either a server name or a
+// nick!user@host mask.
+type Prefix struct {
+	Name string
+	User string
+	Host string
+}
+
+// Message is a single IRC protocol line as described by RFC 1459, with the
+// IRCv3 message-tags extension.
+type Message struct {
+	Tags    map[string]string
+	Prefix  Prefix
+	Command string
+	Params  []string
+}
+
+// parsePrefix splits a prefix of the form nick!user@host. Server prefixes
+// only populate Name.
+func parsePrefix(raw string) Prefix {
+	var prefix Prefix
+
+	if i := strings.Index(raw, "@"); i != -1 {
+		prefix.Host = raw[i+1:]
+		raw = raw[:i]
+	}
+
+	if i := strings.Index(raw, "!"); i != -1 {
+		prefix.User = raw[i+1:]
+		raw = raw[:i]
+	}
+
+	prefix.Name = raw
+	return prefix
+}
+
+// String rebuilds the prefix in nick!user@host form.
+func (p Prefix) String() string {
+	result := p.Name
+	if p.User != "" {
+		result += "!" + p.User
+	}
+	if p.Host != "" {
+		result += "@" + p.Host
+	}
+	return result
+}
+
+var tagValueEscapes = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+// parseTags decodes the IRCv3 tag section (without the leading '@').
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		// A trailing lone backslash is dropped, as per the spec.
+		value = strings.TrimSuffix(value, `\`)
+		tags[key] = tagValueEscapes.Replace(value)
+	}
+
+	return tags
+}
+
+// parseMessage parses a raw line received from the server.
+func parseMessage(line string) (*Message, error) {
+	var msg Message
+
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "@") {
+		tags, rest, found := strings.Cut(line[1:], " ")
+		if !found {
+			return nil, errors.New("message has tags but no command")
+		}
+		msg.Tags = parseTags(tags)
+		line = strings.TrimLeft(rest, " ")
+	}
+
+	if strings.HasPrefix(line, ":") {
+		prefix, rest, found := strings.Cut(line[1:], " ")
+		if !found {
+			return nil, errors.New("message has a prefix but no command")
+		}
+		msg.Prefix = parsePrefix(prefix)
+		line = strings.TrimLeft(rest, " ")
+	}
+
+	for line != "" {
+		if strings.HasPrefix(line, ":") && msg.Command != "" {
+			msg.Params = append(msg.Params, line[1:])
+			break
+		}
+
+		var field string
+		field, line, _ = strings.Cut(line, " ")
+		line = strings.TrimLeft(line, " ")
+
+		if msg.Command == "" {
+			msg.Command = strings.ToUpper(field)
+		} else {
+			msg.Params = append(msg.Params, field)
+		}
+	}
+
+	if msg.Command == "" {
+		return nil, errors.New("message has no command")
+	}
+
+	return &msg, nil
+}
+
+// Param returns the parameter at index i, or an empty string if the message
+// doesn't have that many parameters.
+func (m *Message) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// Trailing returns the last parameter, which for PRIVMSG and NOTICE is the
+// text of the message.
+func (m *Message) Trailing() string {
+	return m.Param(len(m.Params) - 1)
+}
+
+// Nick returns the nickname the message came from.
+func (m *Message) Nick() string {
+	return m.Prefix.Name
+}
+
+// String serialises the message back into wire format, without the CRLF.
+func (m *Message) String() string {
+	var sb strings.Builder
+
+	if len(m.Tags) > 0 {
+		sb.WriteString("@")
+		first := true
+		for key, value := range m.Tags {
+			if !first {
+				sb.WriteString(";")
+			}
+			first = false
+			sb.WriteString(key)
+			if value != "" {
+				sb.WriteString("=")
+				sb.WriteString(tagValueUnescapes.Replace(value))
+			}
+		}
+		sb.WriteString(" ")
+	}
+
+	if m.Prefix.Name != "" {
+		sb.WriteString(":")
+		sb.WriteString(m.Prefix.String())
+		sb.WriteString(" ")
+	}
+
+	sb.WriteString(m.Command)
+
+	for i, param := range m.Params {
+		sb.WriteString(" ")
+		if i == len(m.Params)-1 && (param == "" || strings.Contains(param, " ") || strings.HasPrefix(param, ":")) {
+			sb.WriteString(":")
+		}
+		sb.WriteString(param)
+	}
+
+	return sb.String()
+}
+
+var tagValueUnescapes = strings.NewReplacer(";", `\:`, " ", `\s`, `\`, `\\`, "\r", `\r`, "\n", `\n`)
+
+// MAX_LINE_LENGTH is the longest line the server will relay, including the
+// prefix it adds and the trailing CRLF.
+const MAX_LINE_LENGTH = 512
+
+// truncateUTF8 cuts s to at most max bytes without splitting a rune.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// splitMessage breaks text into lines of at most max bytes. Embedded
+// newlines start a new line, lines are broken between words where
+// possible, and long words are broken between runes.
+func splitMessage(text string, max int) []string {
+	var lines []string
+
+	if max < utf8.UTFMax {
+		max = utf8.UTFMax
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	for _, paragraph := range strings.Split(text, "\n") {
+		var line string
+
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len(line)+1+len(word) <= max {
+				line += " " + word
+				continue
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			for len(word) > max {
+				part := truncateUTF8(word, max)
+				lines = append(lines, part)
+				word = word[len(part):]
+			}
+			line = word
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
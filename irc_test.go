@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{
+			line: "PING :irc.libera.chat",
+			want: Message{Command: "PING", Params: []string{"irc.libera.chat"}},
+		},
+		{
+			line: ":alice!~al@example.com PRIVMSG #lurking :hello there\r\n",
+			want: Message{
+				Prefix:  Prefix{Name: "alice", User: "~al", Host: "example.com"},
+				Command: "PRIVMSG",
+				Params:  []string{"#lurking", "hello there"},
+			},
+		},
+		{
+			line: "@account=alice;time=2024-01-01T00:00:00Z :alice!al@host privmsg #c :!weather",
+			want: Message{
+				Tags:    map[string]string{"account": "alice", "time": "2024-01-01T00:00:00Z"},
+				Prefix:  Prefix{Name: "alice", User: "al", Host: "host"},
+				Command: "PRIVMSG",
+				Params:  []string{"#c", "!weather"},
+			},
+		},
+		{
+			line: ":irc.example.com 005 benbot CHANTYPES=# PREFIX=(ov)@+ :are supported",
+			want: Message{
+				Prefix:  Prefix{Name: "irc.example.com"},
+				Command: "005",
+				Params:  []string{"benbot", "CHANTYPES=#", "PREFIX=(ov)@+", "are supported"},
+			},
+		},
+		{
+			line: ":bob!b@h   JOIN   #c",
+			want: Message{
+				Prefix:  Prefix{Name: "bob", User: "b", Host: "h"},
+				Command: "JOIN",
+				Params:  []string{"#c"},
+			},
+		},
+		{
+			line: ":bob!b@h PRIVMSG #c ::)",
+			want: Message{
+				Prefix:  Prefix{Name: "bob", User: "b", Host: "h"},
+				Command: "PRIVMSG",
+				Params:  []string{"#c", ":)"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseMessage(test.line)
+		if err != nil {
+			t.Errorf("parseMessage(%q) failed: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("parseMessage(%q) = %+v, want %+v", test.line, *got, test.want)
+		}
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"@account=alice",
+		":alice!al@host",
+		"@a=b :alice!al@host",
+	} {
+		if _, err := parseMessage(line); err == nil {
+			t.Errorf("parseMessage(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want map[string]string
+	}{
+		{"account=alice", map[string]string{"account": "alice"}},
+		{"a=1;b;c=", map[string]string{"a": "1", "b": "", "c": ""}},
+		{`msg=semi\:colon\sand\sspace`, map[string]string{"msg": "semi;colon and space"}},
+		{`path=back\\slash`, map[string]string{"path": `back\slash`}},
+		{`lines=one\r\ntwo`, map[string]string{"lines": "one\r\ntwo"}},
+		{`trailing=oops\`, map[string]string{"trailing": "oops"}},
+		{"+example.com/vendor=x;;", map[string]string{"+example.com/vendor": "x"}},
+	}
+
+	for _, test := range tests {
+		if got := parseTags(test.raw); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本", 4, "日"},
+		{"日本", 2, ""},
+		{"", 0, ""},
+	}
+
+	for _, test := range tests {
+		if got := truncateUTF8(test.s, test.max); got != test.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", test.s, test.max, got, test.want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want []string
+	}{
+		{"one two three", 7, []string{"one two", "three"}},
+		{"  spaced   out  ", 100, []string{"spaced out"}},
+		{"first\nsecond\r\nthird\rfourth", 100, []string{"first", "second", "third", "fourth"}},
+		{"a\n\nb", 100, []string{"a", "b"}},
+		{"", 100, nil},
+		// Words too long for a line are broken wherever they have to be...
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"hi abcdefghij ok", 4, []string{"hi", "abcd", "efgh", "ij", "ok"}},
+		// ...but never in the middle of a rune.
+		{"日本語です", 7, []string{"日本", "語で", "す"}},
+		{"hi 日本語", 5, []string{"hi", "日", "本", "語"}},
+		// A line always has room for at least one rune.
+		{"日本", 1, []string{"日", "本"}},
+	}
+
+	for _, test := range tests {
+		if got := splitMessage(test.text, test.max); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", test.text, test.max, got, test.want)
+		}
+	}
+}
+
+func TestMessageBudget(t *testing.T) {
+	b := &IRCBot{nick: "benbot"}
+	text := strings.Repeat("lorem ipsum dolor sit amet ", 60)
+
+	check := func(prefix string) {
+		t.Helper()
+
+		budget := b.messageBudget("PRIVMSG", "#lurking")
+		line := prefix + " PRIVMSG #lurking :" + strings.Repeat("a", budget) + "\r\n"
+		if len(line) != MAX_LINE_LENGTH {
+			t.Errorf("with prefix %s a full line is %d bytes, want %d", prefix, len(line), MAX_LINE_LENGTH)
+		}
+
+		for _, part := range splitMessage(text, budget) {
+			if line := prefix + " PRIVMSG #lurking :" + part + "\r\n"; len(line) > MAX_LINE_LENGTH {
+				t.Errorf("with prefix %s split line is %d bytes, over %d", prefix, len(line), MAX_LINE_LENGTH)
+			}
+		}
+	}
+
+	// Until the server shows us our own prefix, the longest likely one is
+	// assumed.
+	check(":benbot!" + strings.Repeat("x", 10) + "@" + strings.Repeat("x", 63))
+
+	b.setHost("~benbot", "user/benbot")
+	check(":benbot!~benbot@user/benbot")
+}
@@ -2,48 +2,128 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
-	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
-const (
-	CONN_HOST            = "irc.libera.chat"
-	CONN_PORT            = "6697"
-	CONN_TYPE            = "tcp"
-	BOT_NAME             = "benbot"
-	SECURE               = true
-	USE_CHANNEL_PASSWORD = true
-	USE_NICKSERV         = true
-)
+// CONN_TYPE is the network we dial. Everything else about the connection
+// comes from the config; see config.go.
+const CONN_TYPE = "tcp"
 
 // IRCBot represents an IRC bot.
 type IRCBot struct {
-	conn net.Conn
+	conn    net.Conn
+	scanner *bufio.Scanner
+	queue   *sendQueue
+	workers *workerPool
+	roster  *roster
+	// accounts caches which services account each nick is logged in to.
+	accounts *accountCache
+	// channelSettings say what the bot does in each channel.
+	channelSettings *channelSettingsCache
+
+	ctcpLimit ctcpLimiter
+	// databaseDownLimit spaces out telling each nick the database is down.
+	databaseDownLimit nickLimiter
+
+	// mu guards the connection state below, which is updated by the
+	// receiving goroutine and read by everything else.
+	mu            sync.RWMutex
+	caps          map[string]bool
+	availableCaps map[string]string
+
+	// nicks is the primary nick followed by the alternates, nickIndex is the
+	// one we last asked for, and nick is the one we actually have.
+	nicks     []string
+	nickIndex int
+	nick      string
+	prefix    Prefix
+	welcomed  bool
+	isupport  map[string]string
+
+	// joined maps the channels we're in to their keys, and keys holds the
+	// keys we've sent with JOIN but not yet seen confirmed.
+	joined map[string]string
+	keys   map[string]string
+
+	// config holds the settings that can be reloaded while connected, and
+	// owners and prefixes are parsed from it. Owners always have
+	// RoleOwner, whatever the database says, and prefixes mark a message as
+	// a command.
+	config   *Config
+	owners   []ircUser
+	prefixes CommandPrefixes
+
+	sasl *SASLConfig
+	// nickservPassword is used to identify after registration when SASL
+	// isn't in use. identified is closed once NickServ has answered.
+	nickservPassword string
+	identified       chan struct{}
+	identifiedOnce   sync.Once
 }
 
-// NewIRCBot creates a new instance of IRCBot.
-func NewIRCBot(server, port, nickname string, secure bool) (*IRCBot, error) {
-	var bot IRCBot
+// NewIRCBot creates a new instance of IRCBot and returns once the server has
+// finished registering it. The first of nicks is the one we want; the rest
+// are tried in order if it's taken. The connection uses TLS unless
+// tlsConfig is nil. If sasl is not nil the bot authenticates during
+// registration and fails if the server rejects it. Lines are sent at most
+// one every floodInterval after a burst of floodBurst.
+func NewIRCBot(server, port string, nicks []string, tlsConfig *TLSConfig, sasl *SASLConfig, floodInterval time.Duration, floodBurst int) (*IRCBot, error) {
+	nickname := nicks[0]
+	bot := IRCBot{
+		caps:            make(map[string]bool),
+		availableCaps:   make(map[string]string),
+		nicks:           nicks,
+		nick:            nickname,
+		isupport:        make(map[string]string),
+		roster:          newRoster(),
+		accounts:        newAccountCache(),
+		channelSettings: newChannelSettingsCache(),
+		joined:          make(map[string]string),
+		keys:            make(map[string]string),
+		sasl:            sasl,
+		identified:      make(chan struct{}),
+	}
 	var err error
 
-	if secure {
-		config := &tls.Config{InsecureSkipVerify: true}
-		bot.conn, err = tls.Dial(CONN_TYPE, fmt.Sprintf("%s:%s", server, port), config)
+	if tlsConfig != nil {
+		var config *tls.Config
+		config, err = tlsConfig.build(server)
+		if err != nil {
+			return nil, err
+		}
+		bot.conn, err = tls.Dial(CONN_TYPE, net.JoinHostPort(server, port), config)
 	} else {
-		bot.conn, err = net.Dial(CONN_TYPE, fmt.Sprintf("%s:%s", server, port))
+		bot.conn, err = net.Dial(CONN_TYPE, net.JoinHostPort(server, port))
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Perform IRC handshake
+	bot.scanner = bufio.NewScanner(bot.conn)
+	bot.queue = newSendQueue(bot.writeLine, floodInterval, floodBurst)
+	bot.workers = newWorkerPool(COMMAND_WORKERS, COMMAND_BACKLOG, COMMAND_TIMEOUT)
+
+	// Don't wait forever on a server that accepts the connection but never
+	// finishes registering us.
+	bot.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+
+	// Perform IRC handshake. CAP LS goes first so the server holds
+	// registration open until we send CAP END.
+	err = bot.sendRaw("CAP LS 302")
+	if err != nil {
+		fmt.Println("Error sending CAP LS:", err)
+	}
+
 	err = bot.sendRaw(fmt.Sprintf("NICK %s", nickname))
 	if err != nil {
 		fmt.Println("Error sending NICK:", err)
@@ -54,16 +134,27 @@ func NewIRCBot(server, port, nickname string, secure bool) (*IRCBot, error) {
 		fmt.Println("Error sending USER:", err)
 	}
 
+	if err := bot.negotiateCaps(); err != nil {
+		bot.close()
+		return nil, err
+	}
+
+	if err := bot.waitForRegistration(); err != nil {
+		bot.close()
+		return nil, err
+	}
+
 	return &bot, nil
 }
 
-// sendRaw sends a raw IRC command to the server.
-func (b *IRCBot) sendRaw(command string) error {
+// writeLine writes a line straight to the connection. Everything else
+// should go through the send queue.
+func (b *IRCBot) writeLine(line string) error {
 	if b.conn == nil {
 		return errors.New("connection is nil")
 	}
 
-	_, err := fmt.Fprintf(b.conn, "%s\r\n", command)
+	_, err := fmt.Fprintf(b.conn, "%s\r\n", line)
 	if err != nil {
 		return err
 	}
@@ -71,8 +162,53 @@ func (b *IRCBot) sendRaw(command string) error {
 	return nil
 }
 
+// sendRaw sends a raw IRC command to the server. Replies the server is
+// waiting on (PONG, CAP and AUTHENTICATE) go on the send queue's priority
+// lane; everything else is throttled like messages are.
+func (b *IRCBot) sendRaw(command string) error {
+	if b.queue == nil {
+		return errors.New("connection is nil")
+	}
+
+	// A stray newline would let the rest of the string through as another
+	// command, and anything past the limit gets cut off by the server.
+	command = strings.NewReplacer("\r", " ", "\n", " ").Replace(command)
+	command = truncateUTF8(command, MAX_LINE_LENGTH-len("\r\n"))
+
+	verb, _, _ := strings.Cut(command, " ")
+	switch strings.ToUpper(verb) {
+	case "PONG", "CAP", "AUTHENTICATE":
+		return b.queue.pushPriority(command)
+	default:
+		return b.queue.push(SERVER_TARGET, command)
+	}
+}
+
+// dispatch hands slow work to the worker pool so the read loop can get back
+// to answering PINGs. It returns false if the pool is too busy to take it.
+func (b *IRCBot) dispatch(j job) bool {
+	if !b.workers.submit(j) {
+		fmt.Println("Worker pool is full, dropping work")
+		return false
+	}
+	return true
+}
+
+// close says goodbye to the server and closes the connection, dropping
+// anything still queued.
+func (b *IRCBot) close() {
+	b.workers.stop()
+	b.queue.close()
+	b.writeLine("QUIT")
+	b.conn.Close()
+}
+
 // joinChannel joins a specified IRC channel.
 func (b *IRCBot) joinChannel(channel string, password string) {
+	b.mu.Lock()
+	b.keys[strings.ToLower(channel)] = password
+	b.mu.Unlock()
+
 	if password != "" {
 		b.sendRaw(fmt.Sprintf("JOIN %s %s", channel, password))
 	} else {
@@ -80,213 +216,358 @@ func (b *IRCBot) joinChannel(channel string, password string) {
 	}
 }
 
-// sendMessage sends a message to a specified IRC channel.
-func (b *IRCBot) sendMessage(channel, message string) {
-	b.sendRaw(fmt.Sprintf("PRIVMSG %s :%s", channel, message))
-	// throttle messages to avoid being kicked
-	time.Sleep(200 * time.Millisecond)
+// isChannel reports whether target is a channel name rather than a nick,
+// going by the CHANTYPES the server advertised.
+func (b *IRCBot) isChannel(target string) bool {
+	if target == "" {
+		return false
+	}
+
+	b.mu.RLock()
+	chantypes, ok := b.isupport["CHANTYPES"]
+	b.mu.RUnlock()
+
+	if !ok {
+		chantypes = "#&"
+	}
+	return strings.ContainsRune(chantypes, rune(target[0]))
 }
 
-// receiveMessages continuously reads and processes messages from the IRC server.
-func (b *IRCBot) receiveMessages() {
+// replyTarget returns where a reply to message should go: the sender if it
+// was a private message sent to our nick, otherwise wherever it was sent.
+func (b *IRCBot) replyTarget(message *Message) string {
+	if target := message.Param(0); target != "" && !b.isMe(target) {
+		return target
+	}
+	return message.Nick()
+}
 
-	// TODO: Need to learn how to declare globals.
-	// I don't want to pass it as an argument, as it feels like that is misleading.
-	// It leads you to think we're receiving messages from a channel, rather than a server.
-	CHANNEL := os.Getenv("CHANNEL")
+// IDENTIFY_TIMEOUT is how long we wait for NickServ to answer IDENTIFY
+// before joining channels anyway.
+const IDENTIFY_TIMEOUT = 30 * time.Second
 
-	if CHANNEL == "" {
-		log.Fatal("CHANNEL environment variable not set")
+// identify identifies with NickServ, if the bot has a password for it, and
+// waits up to IDENTIFY_TIMEOUT for it to answer so channels don't see us
+// before we have our cloak. It gives up early if done is closed.
+func (b *IRCBot) identify(done <-chan struct{}) {
+	if b.nickservPassword == "" {
+		return
+	}
+	b.sendRaw(fmt.Sprintf("PRIVMSG nickserv :identify %s", b.nickservPassword))
+
+	timer := time.NewTimer(IDENTIFY_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case <-b.identified:
+	case <-done:
+	case <-timer.C:
+		fmt.Println("NickServ didn't answer within", IDENTIFY_TIMEOUT, "so joining anyway")
 	}
+}
 
-	TRUSTED_USERS := strings.Split(os.Getenv("TRUSTED_USERS"), ",")
+// identifyAnswered stops identify waiting.
+func (b *IRCBot) identifyAnswered() {
+	b.identifiedOnce.Do(func() { close(b.identified) })
+}
 
-	if len(TRUSTED_USERS) == 0 {
-		log.Fatal("TRUSTED_USERS environment variable not set")
+// parseNickServIdentify reads NickServ's answer to IDENTIFY, returning
+// whether text is one and whether it worked. Atheme says "You are now
+// identified for <account>." and Anope "Password accepted - you are now
+// recognized.".
+func parseNickServIdentify(text string) (identified, ok bool) {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "you are now identified"), strings.Contains(text, "password accepted"):
+		return true, true
+	case strings.Contains(text, "invalid password"), strings.Contains(text, "password incorrect"):
+		return false, true
 	}
+	return false, false
+}
 
-	scanner := bufio.NewScanner(b.conn)
-	for scanner.Scan() {
-		message := scanner.Text()
-		fmt.Println("Received:", message)
+// messageBudget returns how many bytes of text fit in a "<command> <target>
+// :<text>" line once the server has added our nick!user@host to the front.
+// Until we've seen our own prefix we assume the longest likely one.
+func (b *IRCBot) messageBudget(command, target string) int {
+	b.mu.RLock()
+	nick, user, host := b.nick, b.prefix.User, b.prefix.Host
+	b.mu.RUnlock()
 
-		event := strings.Split(message, " ")[1]
-		user := strings.ToLower(getUserFromMessage(message))
-		userIsTrusted := false
+	if user == "" {
+		user = strings.Repeat("x", 10)
+	}
+	if host == "" {
+		host = strings.Repeat("x", 63)
+	}
 
-		for _, line := range TRUSTED_USERS {
-			if strings.EqualFold(user, line) {
-				userIsTrusted = true
-			}
-		}
+	prefix := fmt.Sprintf(":%s!%s@%s ", nick, user, host)
+	return MAX_LINE_LENGTH - len(prefix) - len(fmt.Sprintf("%s %s :", command, target)) - len("\r\n")
+}
+
+// setHost records our own user and host as the server relays them, from our
+// JOINs or RPL_HOSTHIDDEN, so messageBudget can be exact.
+func (b *IRCBot) setHost(user, host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-		// Add your message processing logic here
-		// Example: check for PING messages and respond with PONG
-		if strings.HasPrefix(message, "PING") {
-			fmt.Println("Sending: PONG " + message[5:])
-			b.sendRaw("PONG " + message[5:])
+	if user != "" {
+		b.prefix.User = user
+	}
+	if host != "" {
+		b.prefix.Host = host
+	}
+}
+
+// sendMessage sends a message to a specified IRC channel, split over as
+// many lines as it takes.
+func (b *IRCBot) sendMessage(channel, message string) {
+	// The send queue throttles messages to avoid being kicked
+	for _, line := range splitMessage(message, b.messageBudget("PRIVMSG", channel)) {
+		b.queue.push(channel, fmt.Sprintf("PRIVMSG %s :%s", channel, line))
+	}
+}
+
+// receiveMessages continuously reads and processes messages from the IRC
+// server. It returns when the connection is lost.
+func (b *IRCBot) receiveMessages() {
+	b.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+
+	for b.scanner.Scan() {
+		b.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+
+		line := b.scanner.Text()
+		fmt.Println("Received:", line)
+
+		message, err := parseMessage(line)
+		if err != nil {
+			fmt.Println("Error parsing message:", err)
+			continue
 		}
 
-		if event == "JOIN" {
+		user := strings.ToLower(getUserFromMessage(message))
 
-			if user != BOT_NAME {
-				resp, err := getGreetings(user)
+		switch message.Command {
+		case "PING":
+			fmt.Println("Sending: PONG :" + message.Trailing())
+			b.sendRaw("PONG :" + message.Trailing())
 
-				if err != nil {
-					fmt.Println("Error retrieving greeting message:", err)
-				}
+		case "CAP":
+			b.handleCap(message)
+
+		case "JOIN":
+			channel := message.Param(0)
+			b.updateRoster(message)
+			b.handleAccountMessage(message)
 
-				b.sendMessage(CHANNEL, resp)
+			if b.isMe(user) {
+				b.trackChannel(channel, true)
+				b.setHost(message.Prefix.User, message.Prefix.Host)
 			}
 
-			if user != BOT_NAME {
-				resp, err := sendRelayMessage(user)
+			if !b.isMe(user) {
+				account := b.knownAccount(message)
+				b.dispatch(func(ctx context.Context) {
+					b.greetUser(ctx, user, account, channel)
+				})
+			}
 
-				if err != nil {
-					fmt.Println("Error sending relay message:", err)
-				}
+		case "PART":
+			b.updateRoster(message)
+			b.forgetAccount(user)
+			if b.isMe(user) {
+				b.trackChannel(message.Param(0), false)
+				b.pruneAccounts()
+			}
 
-				for _, line := range resp {
-					b.sendMessage(CHANNEL, line)
-				}
+		case "KICK":
+			b.updateRoster(message)
+			b.forgetAccount(message.Param(1))
+			if b.isMe(message.Param(1)) {
+				b.trackChannel(message.Param(0), false)
+				b.pruneAccounts()
 			}
-		}
 
-		// case statement for commands
-		if userIsTrusted {
-			if event == "PRIVMSG" {
-				command := strings.Split(message, " ")[3]
-				switch command {
-				case ":!hello":
-					b.sendMessage(CHANNEL, "Hello, world!")
-				case ":!ping":
-					b.sendMessage(CHANNEL, "pong")
-				case ":!time":
-					b.sendMessage(CHANNEL, time.Now().String())
-				case ":!weather":
-					if len(strings.Split(message, " ")) > 4 {
-						location := strings.Join(strings.Split(message, " ")[4:], " ")
-						fmt.Println("Checking weather for location:", location)
-
-						if forecast, err := handleWeather(location); err != nil {
-							fmt.Println("Error getting weather:", err)
-						} else {
-							fmt.Println("Sending weather forecast:", forecast)
-							for _, line := range forecast {
-								b.sendMessage(CHANNEL, line)
-							}
-						}
-					} else {
-						resp, err := getHelp("weather")
-						if err != nil {
-							fmt.Println("Error retrieving help for weather: ", err)
-						}
-						for _, line := range resp {
-							b.sendMessage(CHANNEL, line)
-						}
-					}
-				case ":!relay_url":
-					if len(strings.Split(message, " ")) > 4 {
-						resp, err := addRelayMessage(message)
-						if err != nil {
-							fmt.Println("Error adding relay message:", err)
-						}
-						for _, line := range resp {
-							b.sendMessage(CHANNEL, line)
-						}
-					} else {
-						resp, err := getHelp("relay_url")
-						if err != nil {
-							fmt.Println("Error retrieving help for relay url messages:", err)
-						}
-						for _, line := range resp {
-							b.sendMessage(CHANNEL, line)
-						}
-					}
-				case ":!help":
-					if len(strings.Split(message, " ")) > 4 {
-            feature := strings.Split(message, " ")[4]
-            resp, err := getHelp(feature)
-						if err != nil {
-							fmt.Println("Error retrieving help message:", err)
-						}
-						for _, line := range resp {
-							b.sendMessage(CHANNEL, line)
-						}
-					} else {
-						resp, err := getHelp("")
-						if err != nil {
-							fmt.Println("Error retrieving general help message:", err)
-						}
-						for _, line := range resp {
-							b.sendMessage(CHANNEL, line)
-						}
+		case "QUIT":
+			b.updateRoster(message)
+			b.forgetAccount(user)
+
+		case "NICK":
+			b.updateRoster(message)
+			b.renameAccount(user, message.Param(0))
+			b.handleNickChange(message)
+
+		case "ACCOUNT", "330", "318":
+			b.handleAccountMessage(message)
+
+		case "MODE":
+			b.updatePrefixes(message)
+
+		case "353":
+			b.handleNames(message)
+
+		case "366":
+			b.handleEndOfNames(message)
+
+		case "005":
+			b.handleISupport(message)
+
+		case "396":
+			b.setHost("", message.Param(1))
+
+		case "731":
+			b.handleMonitorOffline(message)
+
+		case "ERROR":
+			fmt.Println("Server closed the connection:", message.Trailing())
+
+		case "900":
+			// RPL_LOGGEDIN
+			fmt.Println("Logged in:", message.Trailing())
+			b.identifyAnswered()
+
+		case "NOTICE":
+			b.handleAccountMessage(message)
+			if strings.EqualFold(user, "NickServ") {
+				if identified, ok := parseNickServIdentify(message.Trailing()); ok {
+					if !identified {
+						fmt.Println("NickServ rejected our password:", message.Trailing())
 					}
-					// case ":!quit":
-					//   b.sendMessage(CHANNEL, "Bye!")
-					//   b.sendRaw("QUIT")
-					//   b.conn.Close()
-					//   return
+					b.identifyAnswered()
 				}
 			}
-		} else if event == "PRIVMSG" {
-			fmt.Println("User not trusted:", user)
+			if command, params, ok := parseCTCP(message.Trailing()); ok {
+				fmt.Println("CTCP", command, "reply from", user+":", params)
+			}
+
+		case "PRIVMSG":
+			// With echo-message the server sends our own messages back.
+			if b.isMe(user) {
+				continue
+			}
+
+			if command, params, ok := parseCTCP(message.Trailing()); ok {
+				b.handleCTCP(message, command, params)
+				continue
+			}
+
+			b.handleCommandMessage(message)
 		}
 	}
+
+	if err := b.scanner.Err(); err != nil {
+		fmt.Println("Error reading from server:", err)
+	}
 }
 
-func main() {
+// greetUser greets someone who just joined channel, and passes on any relay
+// messages left for them there, as long as their settings and the
+// channel's allow it.
+func (b *IRCBot) greetUser(ctx context.Context, user, account, channel string) {
+	settings, err := getUserSettings(ctx, user, account)
+
+	if errors.Is(err, errDatabaseDown) {
+		// Without the database there's no telling whether they want
+		// greeting or have messages waiting, so say so rather than
+		// guessing.
+		if b.settingsFor(channel).Greet {
+			b.sendMessage(channel, fmt.Sprintf("Hello %s! I can't reach my database right now, so I can't check for messages left for you.", user))
+		}
+		return
+	}
 
-	err := OpenDatabase()
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Error retrieving user settings:", err)
 	}
 
-	defer CloseDatabase()
+	if channelSettings := b.settingsFor(channel); channelSettings.Greet {
+		greeting, err := getGreetings(ctx, user, settings, channelSettings.Language)
 
-	CHANNEL := os.Getenv("CHANNEL")
-	CHANNEL_PASSWORD := ""
+		if err != nil {
+			fmt.Println("Error retrieving greeting message:", err)
+		}
 
-	if CHANNEL == "" {
-		log.Fatal("CHANNEL environment variable not set")
+		if greeting != "" {
+			b.sendMessage(channel, greeting)
+		}
 	}
 
-	if USE_CHANNEL_PASSWORD {
-		CHANNEL_PASSWORD = os.Getenv("CHANNEL_PASSWORD")
-		if CHANNEL_PASSWORD == "" {
-			log.Fatal("CHANNEL_PASSWORD environment variable not set")
-		}
+	resp, err := sendRelayMessage(ctx, user, channel, settings)
+
+	if err != nil {
+		fmt.Println("Error sending relay message:", err)
+	}
+
+	target := channel
+	if settings.RelayPrivate {
+		target = user
 	}
 
-	bot, err := NewIRCBot(CONN_HOST, CONN_PORT, BOT_NAME, SECURE)
+	for _, line := range resp {
+		b.sendMessage(target, line)
+	}
+}
+
+// connectBot connects and registers a bot using config.
+func connectBot(config *Config) (*IRCBot, error) {
+	bot, err := NewIRCBot(config.Server.Host, config.Server.Port, config.nicks(), config.tlsConfig(), config.saslConfig(), config.Server.FloodInterval, config.Server.FloodBurst)
 	if err != nil {
-		fmt.Println("Error creating IRC bot:", err)
-		return
+		return nil, err
+	}
+	if config.Server.SASLMechanism == "" {
+		bot.nickservPassword = config.Server.NickServPassword
 	}
-	defer bot.conn.Close()
+	bot.applyConfig(config)
 
-	// Start a goroutine to handle incoming messages
-	go bot.receiveMessages()
-	// wait for 10 seconds before joining the channel
-	<-time.After(10 * time.Second)
-	bot.joinChannel(CHANNEL, CHANNEL_PASSWORD)
+	ctx, cancel := context.WithTimeout(context.Background(), COMMAND_TIMEOUT)
+	defer cancel()
+	if err := bot.loadChannelSettings(ctx); err != nil {
+		fmt.Println("Error loading channel settings:", err)
+	}
 
-	if USE_NICKSERV {
-		NICKSERV_PASSWORD := os.Getenv("NICKSERV_PASSWORD")
-		if NICKSERV_PASSWORD == "" {
-			log.Fatal("NICKSERV_PASSWORD environment variable not set")
+	return bot, nil
+}
+
+func main() {
+	configPath := flag.String("config", DEFAULT_CONFIG_FILE, "path to the YAML config file")
+	flag.Parse()
+
+	// A config file named on the command line has to exist; the default
+	// one doesn't.
+	required := false
+	flag.Visit(func(f *flag.Flag) {
+		required = required || f.Name == "config"
+	})
+
+	// "migrate up|down|status|grants" manages the database schema and
+	// exits. It only needs the database settings.
+	if flag.Arg(0) == "migrate" {
+		config, err := readConfig(*configPath, required, func(c *Config) []error {
+			return c.Database.validate()
+		})
+		if err == nil {
+			err = runMigrateCommand(config.Database, flag.Args()[1:])
 		}
-		bot.sendRaw(fmt.Sprintf("PRIVMSG nickserv :identify %s", NICKSERV_PASSWORD))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// keep channel alive.
-	// TODO: This is likely a bit of a hack. There's probably a better way to do this.
-	for {
-		<-time.After(10 * time.Second)
+	config, err := loadConfig(*configPath, required)
+	if err != nil {
+		log.Fatal(err)
 	}
-	// Example: Send a message to the channel every 10 seconds
-	// for {
-	// 	bot.sendMessage(CHANNEL, "Hello, IRC!")
-	// 	<-time.After(10 * time.Second)
-	// }
+
+	err = OpenDatabase(config.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer CloseDatabase()
+
+	if len(config.Bot.Owners) == 0 {
+		fmt.Println("No owners are configured, so only roles from the database apply")
+	}
+
+	superviseBot(config, watchConfig(*configPath, required))
 }
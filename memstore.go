@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store that only lives as long as the process, for
+// trying the bot out without a database.
+type memoryStore struct {
+	mu        sync.Mutex
+	greetings []Greeting
+	relays    []relayMessage
+	relayed   map[int]bool
+	users     []ircUser
+	channels  map[string]ChannelSettings
+	nextID    int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		relayed:  make(map[int]bool),
+		channels: make(map[string]ChannelSettings),
+	}
+}
+
+// id hands out IDs for new rows. Callers hold s.mu.
+func (s *memoryStore) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *memoryStore) Greetings(ctx context.Context) ([]Greeting, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Greeting(nil), s.greetings...), nil
+}
+
+func (s *memoryStore) SaveRelayMessage(ctx context.Context, message relayMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message.Id = s.id()
+	s.relays = append(s.relays, message)
+	return nil
+}
+
+func (s *memoryStore) PendingRelayMessages(ctx context.Context) ([]relayMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []relayMessage
+	for _, message := range s.relays {
+		if !s.relayed[message.Id] {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryStore) MarkRelayMessageSent(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.relayed[id] = true
+	return nil
+}
+
+func (s *memoryStore) UsersFor(ctx context.Context, nick, account string) ([]ircUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []ircUser
+	for _, user := range s.users {
+		switch {
+		case user.Hostmask != "",
+			account != "" && strings.EqualFold(user.Account, account),
+			nick != "" && user.Account == "" && strings.EqualFold(user.Username, nick):
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryStore) AddUser(ctx context.Context, user ircUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.ID = s.id()
+	if user.CreatedOn.IsZero() {
+		user.CreatedOn = time.Now()
+	}
+	s.users = append(s.users, user)
+	return nil
+}
+
+func (s *memoryStore) UpdateUser(ctx context.Context, user ircUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == user.ID {
+			s.users[i].Role = user.Role
+			s.users[i].Settings = user.Settings
+			return nil
+		}
+	}
+	return fmt.Errorf("no user with id %d", user.ID)
+}
+
+func (s *memoryStore) ChannelSettings(ctx context.Context) ([]ChannelSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var settings []ChannelSettings
+	for _, channel := range s.channels {
+		settings = append(settings, channel)
+	}
+	return settings, nil
+}
+
+func (s *memoryStore) SaveChannelSettings(ctx context.Context, settings ChannelSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings.Channel = strings.ToLower(settings.Channel)
+	s.channels[settings.Channel] = settings
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
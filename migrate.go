@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// Migrations live in migrations/<driver>/ as numbered pairs of files, e.g.
+// 0002_irc_users_roles.up.sql and 0002_irc_users_roles.down.sql, and are
+// built into the binary.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// MIGRATION_LOCK_ID is the Postgres advisory lock held while migrating, so
+// two bots starting at once don't both try.
+const MIGRATION_LOCK_ID = 7267346
+
+// migration is one numbered schema change.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the migrations for driver, in order.
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", driver, err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		number, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("badly named migration %s", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// migrator applies and rolls back migrations on a SQL database.
+type migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []migration
+}
+
+func newMigrator(db *sql.DB, driver string) (*migrator, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+// locked runs fn on a connection holding the migration lock. Postgres uses
+// an advisory lock, with each migration in its own transaction. SQLite
+// locks the whole database with BEGIN IMMEDIATE, so everything fn does is
+// one transaction.
+func (m *migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.driver == "sqlite" {
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return err
+		}
+		if err := m.createTable(ctx, conn); err != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+			return err
+		}
+		if err := fn(conn); err != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "COMMIT")
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", MIGRATION_LOCK_ID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", MIGRATION_LOCK_ID)
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// createTable creates the table recording which migrations have run.
+func (m *migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name varchar(256), applied_on timestamp)")
+	return err
+}
+
+// querier is a *sql.DB or *sql.Conn.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// applied returns when each applied migration was run, by version.
+func (m *migrator) applied(ctx context.Context, q querier) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_on FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedOn sql.NullTime
+		if err := rows.Scan(&version, &appliedOn); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedOn.Time
+	}
+
+	return applied, rows.Err()
+}
+
+// run executes one migration's SQL and records it, in a transaction on
+// Postgres. On SQLite the caller's transaction covers it.
+func (m *migrator) run(ctx context.Context, conn *sql.Conn, sqlText, record string, args ...interface{}) error {
+	if m.driver == "sqlite" {
+		if _, err := conn.ExecContext(ctx, sqlText); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, record, args...)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, sqlText); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every migration that hasn't been yet, returning how many ran.
+func (m *migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.version]; ok {
+				continue
+			}
+
+			fmt.Printf("Applying migration %04d_%s\n", migration.version, migration.name)
+			err := m.run(ctx, conn, migration.up, "INSERT INTO schema_migrations (version, name, applied_on) VALUES ($1, $2, $3)", migration.version, migration.name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.version, migration.name, err)
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down rolls back the most recently applied migration.
+func (m *migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.version]; !ok {
+				continue
+			}
+
+			fmt.Printf("Rolling back migration %04d_%s\n", migration.version, migration.name)
+			err := m.run(ctx, conn, migration.down, "DELETE FROM schema_migrations WHERE version = $1", migration.version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.version, migration.name, err)
+			}
+			return nil
+		}
+
+		fmt.Println("No migrations to roll back")
+		return nil
+	})
+}
+
+// appliedReadOnly is applied without taking the lock or creating
+// anything, so it works for a database user that can't change the schema.
+// With no schema_migrations table yet, nothing has been applied.
+func (m *migrator) appliedReadOnly(ctx context.Context) (map[int]time.Time, error) {
+	query := "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	if m.driver == "sqlite" {
+		query = "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	}
+
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, query).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return make(map[int]time.Time), nil
+	}
+	return m.applied(ctx, m.db)
+}
+
+// Pending returns the migrations that haven't been applied. Like Status,
+// it only reads from the database.
+func (m *migrator) Pending(ctx context.Context) ([]migration, error) {
+	applied, err := m.appliedReadOnly(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// isPermissionError reports whether err means the database user isn't
+// allowed to make a change, e.g. altering a table it doesn't own.
+func isPermissionError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42501" // insufficient_privilege
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case 3, 8, 23: // SQLITE_PERM, SQLITE_READONLY, SQLITE_AUTH
+			return true
+		}
+	}
+	return false
+}
+
+// grantStatements are what the bot's database user needs when someone else
+// owns the tables, e.g. after running "migrate up" as postgres.
+func grantStatements(user string) []string {
+	user = pq.QuoteIdentifier(user)
+	return []string{
+		"GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO " + user + ";",
+		"GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO " + user + ";",
+	}
+}
+
+// Status describes each migration and whether it's been applied. It only
+// reads from the database, so it can be run as the bot's own user while a
+// migration is in progress.
+func (m *migrator) Status(ctx context.Context) ([]string, error) {
+	applied, err := m.appliedReadOnly(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, migration := range m.migrations {
+		status := "pending"
+		if appliedOn, ok := applied[migration.version]; ok {
+			status = "applied " + appliedOn.Format(time.RFC1123)
+		}
+		lines = append(lines, fmt.Sprintf("%04d_%s: %s", migration.version, migration.name, status))
+	}
+	return lines, nil
+}
+
+// MIGRATE_USAGE describes the migrate subcommand.
+const MIGRATE_USAGE = "usage: migrate up|down|status, or migrate grants [user]"
+
+// runMigrateCommand handles "migrate up", "migrate down", "migrate status"
+// and "migrate grants" from the command line.
+func runMigrateCommand(config DatabaseConfig, args []string) error {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[0] != "grants") {
+		return errors.New(MIGRATE_USAGE)
+	}
+	if config.Driver == "memory" {
+		return fmt.Errorf("the memory database has no migrations")
+	}
+
+	// grants prints SQL for the table owner to run, so it doesn't need a
+	// connection.
+	if args[0] == "grants" {
+		if config.Driver != "postgres" {
+			return fmt.Errorf("only postgres has users to grant access to")
+		}
+		user := config.User
+		if len(args) == 2 {
+			user = args[1]
+		}
+		for _, statement := range grantStatements(user) {
+			fmt.Println(statement)
+		}
+		return nil
+	}
+
+	db, err := openSQLDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := newMigrator(db, config.Driver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		count, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", count)
+		if count > 0 && config.Driver == "postgres" {
+			fmt.Println("If the bot connects as a different user, give it access to any new tables with \"migrate grants <user>\"")
+		}
+		return nil
+	case "down":
+		return m.Down(ctx)
+	case "status":
+		lines, err := m.Status(ctx)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return err
+	default:
+		return errors.New(MIGRATE_USAGE)
+	}
+}
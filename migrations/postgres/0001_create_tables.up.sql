@@ -0,0 +1,25 @@
+-- The tables as they were in data/schema.sql before migrations. IF NOT
+-- EXISTS lets this run against databases set up from that dump.
+CREATE TABLE IF NOT EXISTS greetings (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    first_word character varying(64),
+    body character varying(512)
+);
+
+CREATE TABLE IF NOT EXISTS irc_users (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    created_on timestamp without time zone,
+    username character varying(64)
+);
+
+CREATE TABLE IF NOT EXISTS relay_messages (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    "timestamp" timestamp without time zone,
+    from_user character varying(64),
+    to_user character varying(64),
+    description text,
+    suggested_url character varying(4096),
+    was_relayed boolean DEFAULT false
+);
+
+ALTER TABLE relay_messages ADD COLUMN IF NOT EXISTS from_channel character varying(512);
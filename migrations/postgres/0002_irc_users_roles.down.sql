@@ -0,0 +1,3 @@
+ALTER TABLE irc_users DROP COLUMN IF EXISTS role;
+ALTER TABLE irc_users DROP COLUMN IF EXISTS hostmask;
+ALTER TABLE irc_users DROP COLUMN IF EXISTS account;
@@ -0,0 +1,3 @@
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS account character varying(64);
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS hostmask character varying(256);
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS role character varying(16);
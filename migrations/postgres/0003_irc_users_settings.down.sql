@@ -0,0 +1,3 @@
+ALTER TABLE irc_users DROP COLUMN IF EXISTS allow_weather;
+ALTER TABLE irc_users DROP COLUMN IF EXISTS relay_private;
+ALTER TABLE irc_users DROP COLUMN IF EXISTS greet;
@@ -0,0 +1,3 @@
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS greet boolean;
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS relay_private boolean;
+ALTER TABLE irc_users ADD COLUMN IF NOT EXISTS allow_weather boolean;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS channel_settings (
+    channel character varying(512) PRIMARY KEY,
+    greet boolean,
+    prefixes character varying(64),
+    disabled_commands text
+);
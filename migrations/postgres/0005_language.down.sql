@@ -0,0 +1,2 @@
+ALTER TABLE channel_settings DROP COLUMN IF EXISTS language;
+ALTER TABLE greetings DROP COLUMN IF EXISTS language;
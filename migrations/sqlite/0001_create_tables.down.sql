@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS channel_settings;
+DROP TABLE IF EXISTS relay_messages;
+DROP TABLE IF EXISTS irc_users;
+DROP TABLE IF EXISTS greetings;
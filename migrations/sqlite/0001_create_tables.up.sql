@@ -0,0 +1,38 @@
+-- SQLite support arrived with all of these tables in place, so they're
+-- created together. IF NOT EXISTS lets this run against databases created
+-- before migrations.
+CREATE TABLE IF NOT EXISTS greetings (
+    id integer PRIMARY KEY,
+    first_word varchar(64),
+    body varchar(512)
+);
+
+CREATE TABLE IF NOT EXISTS irc_users (
+    id integer PRIMARY KEY,
+    created_on timestamp,
+    username varchar(64),
+    account varchar(64),
+    hostmask varchar(256),
+    role varchar(16),
+    greet boolean,
+    relay_private boolean,
+    allow_weather boolean
+);
+
+CREATE TABLE IF NOT EXISTS relay_messages (
+    id integer PRIMARY KEY,
+    "timestamp" timestamp,
+    from_user varchar(64),
+    to_user varchar(64),
+    description text,
+    suggested_url varchar(4096),
+    was_relayed boolean DEFAULT false,
+    from_channel varchar(512)
+);
+
+CREATE TABLE IF NOT EXISTS channel_settings (
+    channel varchar(512) PRIMARY KEY,
+    greet boolean,
+    prefixes varchar(64),
+    disabled_commands text
+);
@@ -0,0 +1,2 @@
+ALTER TABLE channel_settings DROP COLUMN language;
+ALTER TABLE greetings DROP COLUMN language;
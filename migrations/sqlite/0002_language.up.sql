@@ -0,0 +1,2 @@
+ALTER TABLE greetings ADD COLUMN language varchar(16);
+ALTER TABLE channel_settings ADD COLUMN language varchar(16);
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nickErrors are the replies to NICK that we can recover from by trying the
+// next alternate nick.
+var nickErrors = map[string]bool{
+	"432": true,
+	"433": true,
+	"436": true,
+	"437": true,
+}
+
+// currentNick returns the nick the bot is currently using.
+func (b *IRCBot) currentNick() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nick
+}
+
+// isMe reports whether nick is the bot's current nick.
+func (b *IRCBot) isMe(nick string) bool {
+	return strings.EqualFold(nick, b.currentNick())
+}
+
+// tryNextNick switches to the next alternate nick after the server rejected
+// the one we asked for. It returns false when there are none left.
+func (b *IRCBot) tryNextNick() bool {
+	b.mu.Lock()
+	b.nickIndex++
+	if b.nickIndex >= len(b.nicks) {
+		b.mu.Unlock()
+		return false
+	}
+	nick := b.nicks[b.nickIndex]
+	b.nick = nick
+	b.mu.Unlock()
+
+	fmt.Println("Nick is taken, trying", nick)
+	b.sendRaw("NICK " + nick)
+	return true
+}
+
+// handleNickChange follows NICK messages, keeping track of our own nick and
+// noticing when we get the primary one back.
+func (b *IRCBot) handleNickChange(message *Message) {
+	if !b.isMe(message.Nick()) {
+		return
+	}
+
+	newNick := message.Param(0)
+
+	b.mu.Lock()
+	b.nick = newNick
+	b.mu.Unlock()
+
+	fmt.Println("Now known as", newNick)
+
+	if strings.EqualFold(newNick, b.nicks[0]) && b.supports("MONITOR") {
+		b.sendRaw("MONITOR - " + b.nicks[0])
+	}
+}
+
+// reclaimNick tries to get the primary nick back if registration had to fall
+// back to an alternate. When we're identified we can ask NickServ to regain
+// it; otherwise we MONITOR it and take it as soon as it's free.
+func (b *IRCBot) reclaimNick() {
+	primary := b.nicks[0]
+	if b.isMe(primary) {
+		return
+	}
+
+	switch {
+	case b.sasl != nil || b.nickservPassword != "":
+		fmt.Println("Asking NickServ to regain", primary)
+		b.sendRaw(fmt.Sprintf("PRIVMSG NickServ :REGAIN %s", primary))
+	case b.supports("MONITOR"):
+		fmt.Println("Monitoring", primary, "until it is free")
+		b.sendRaw("MONITOR + " + primary)
+	}
+}
+
+// handleMonitorOffline takes the primary nick when MONITOR tells us its
+// holder has gone (RPL_MONOFFLINE).
+func (b *IRCBot) handleMonitorOffline(message *Message) {
+	primary := b.nicks[0]
+	if b.isMe(primary) {
+		return
+	}
+
+	for _, target := range strings.Split(message.Trailing(), ",") {
+		if strings.EqualFold(target, primary) {
+			b.sendRaw("NICK " + primary)
+		}
+	}
+}
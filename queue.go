@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FLOOD_INTERVAL is how often the token bucket gains a token, which is
+	// the sustained rate we send lines at. FLOOD_BURST is how many lines can
+	// go out back to back before that rate applies. They're the defaults
+	// for server.flood_interval and server.flood_burst.
+	FLOOD_INTERVAL = 1 * time.Second
+	FLOOD_BURST    = 5
+
+	// SERVER_TARGET is the queue raw commands such as JOIN and WHOIS
+	// share, taking turns with the channels. No channel or nick can be
+	// empty, so it can't clash with one.
+	SERVER_TARGET = ""
+)
+
+// errQueueClosed is returned when sending after the connection has gone.
+var errQueueClosed = errors.New("send queue is closed")
+
+// sendQueue sends lines to the server asynchronously, using a token bucket
+// to stay under the server's flood limits. Protocol replies such as PONG
+// jump the queue, and messages are taken from each target in turn so one long
+// reply can't hold up every other channel.
+type sendQueue struct {
+	write    func(line string) error
+	interval time.Duration
+	burst    int
+
+	mu       sync.Mutex
+	tokens   float64
+	refilled time.Time
+	priority []string
+	targets  map[string][]string
+	order    []string
+	closed   bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// newSendQueue creates a queue that sends with write and starts sending.
+func newSendQueue(write func(line string) error, interval time.Duration, burst int) *sendQueue {
+	q := &sendQueue{
+		write:    write,
+		interval: interval,
+		burst:    burst,
+		tokens:   float64(burst),
+		refilled: time.Now(),
+		targets:  make(map[string][]string),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// pushPriority queues a protocol line ahead of every message.
+func (q *sendQueue) pushPriority(line string) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errQueueClosed
+	}
+	q.priority = append(q.priority, line)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+// push queues a line addressed to target behind any others for the same
+// target.
+func (q *sendQueue) push(target, line string) error {
+	target = strings.ToLower(target)
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errQueueClosed
+	}
+	if len(q.targets[target]) == 0 {
+		q.order = append(q.order, target)
+	}
+	q.targets[target] = append(q.targets[target], line)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+// close stops the queue, dropping anything not yet sent.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// refill adds the tokens earned since the last refill. q.mu must be held.
+func (q *sendQueue) refill() {
+	now := time.Now()
+	q.tokens += float64(now.Sub(q.refilled)) / float64(q.interval)
+	if q.tokens > float64(q.burst) {
+		q.tokens = float64(q.burst)
+	}
+	q.refilled = now
+}
+
+// next returns the next line to send, or how long to wait before one is
+// allowed. ok is false when there is nothing queued at all.
+func (q *sendQueue) next() (line string, wait time.Duration, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.refill()
+
+	// Protocol replies never wait, but still use up a token if there is one
+	// so the bucket reflects what the server has seen.
+	if len(q.priority) > 0 {
+		line = q.priority[0]
+		q.priority = q.priority[1:]
+		if q.tokens >= 1 {
+			q.tokens--
+		}
+		return line, 0, true
+	}
+
+	if len(q.order) == 0 {
+		return "", 0, false
+	}
+
+	if q.tokens < 1 {
+		return "", time.Duration((1 - q.tokens) * float64(q.interval)), true
+	}
+	q.tokens--
+
+	// Take one line from the target at the front, then send it to the back
+	// if it has more.
+	target := q.order[0]
+	q.order = q.order[1:]
+	lines := q.targets[target]
+	line = lines[0]
+
+	if len(lines) > 1 {
+		q.targets[target] = lines[1:]
+		q.order = append(q.order, target)
+	} else {
+		delete(q.targets, target)
+	}
+
+	return line, 0, true
+}
+
+func (q *sendQueue) run() {
+	for {
+		line, wait, ok := q.next()
+
+		switch {
+		case !ok:
+			select {
+			case <-q.wake:
+			case <-q.done:
+				return
+			}
+		case wait > 0:
+			select {
+			case <-time.After(wait):
+			case <-q.wake:
+				// Something may have arrived on the priority lane.
+			case <-q.done:
+				return
+			}
+		default:
+			if err := q.write(line); err != nil {
+				q.close()
+				return
+			}
+		}
+	}
+}
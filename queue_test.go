@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestQueue returns a queue that isn't running, so next can be called
+// directly. Its bucket starts full and, with an hour between tokens, never
+// refills during a test.
+func newTestQueue(burst int) *sendQueue {
+	return &sendQueue{
+		interval: time.Hour,
+		burst:    burst,
+		tokens:   float64(burst),
+		refilled: time.Now(),
+		targets:  make(map[string][]string),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// drain takes lines from q until it has to wait or runs dry.
+func drain(q *sendQueue) (lines []string, waiting bool) {
+	for {
+		line, wait, ok := q.next()
+		if !ok {
+			return lines, false
+		}
+		if wait > 0 {
+			return lines, true
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestSendQueueTakesTurns(t *testing.T) {
+	q := newTestQueue(10)
+	q.push("#a", "a1")
+	q.push("#a", "a2")
+	q.push("#a", "a3")
+	q.push("#B", "b1")
+	q.push(SERVER_TARGET, "JOIN #c")
+	q.push("#b", "b2")
+
+	lines, waiting := drain(q)
+	want := []string{"a1", "b1", "JOIN #c", "a2", "b2", "a3"}
+	if !reflect.DeepEqual(lines, want) || waiting {
+		t.Errorf("sent %q (waiting %v), want %q", lines, waiting, want)
+	}
+}
+
+func TestSendQueueBurst(t *testing.T) {
+	q := newTestQueue(3)
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		q.push("#a", line)
+	}
+
+	lines, waiting := drain(q)
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(lines, want) || !waiting {
+		t.Errorf("sent %q (waiting %v), want %q then a wait", lines, waiting, want)
+	}
+
+	// Priority lines still go straight out with the bucket empty.
+	q.pushPriority("PONG :irc.example.com")
+	if line, wait, ok := q.next(); line != "PONG :irc.example.com" || wait != 0 || !ok {
+		t.Errorf("next() = %q, %s, %v, want the PONG straight away", line, wait, ok)
+	}
+
+	// Two intervals later there are two more tokens.
+	q.refilled = q.refilled.Add(-2 * q.interval)
+	lines, waiting = drain(q)
+	if want := []string{"4", "5"}; !reflect.DeepEqual(lines, want) || waiting {
+		t.Errorf("after refilling sent %q (waiting %v), want %q", lines, waiting, want)
+	}
+}
+
+func TestSendQueueRefillIsCapped(t *testing.T) {
+	q := newTestQueue(2)
+	q.tokens = 0
+	q.refilled = time.Now().Add(-time.Duration(100) * q.interval)
+	q.refill()
+	if q.tokens != 2 {
+		t.Errorf("tokens = %v after a long idle, want the burst of 2", q.tokens)
+	}
+}
+
+func TestSendQueueSends(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	done := make(chan struct{})
+
+	q := newSendQueue(func(line string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, line)
+		if len(sent) == 4 {
+			close(done)
+		}
+		return nil
+	}, 10*time.Millisecond, 2)
+	defer q.close()
+
+	start := time.Now()
+	for _, line := range []string{"1", "2", "3", "4"} {
+		q.push("#a", line)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the queue")
+	}
+
+	// Two lines fit in the burst; the other two wait a token each.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("sent 4 lines in %s, faster than the bucket allows", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+
+	q.close()
+	if err := q.push("#a", "late"); err != errQueueClosed {
+		t.Errorf("push after close = %v, want errQueueClosed", err)
+	}
+}
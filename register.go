@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registrationErrors are the replies that mean the server won't let us
+// finish registering.
+var registrationErrors = map[string]string{
+	"432": "erroneous nickname",
+	"433": "nickname is already in use",
+	"436": "nickname collision",
+	"437": "nickname is temporarily unavailable",
+	"464": "password incorrect",
+	"465": "banned from this server",
+}
+
+// registrationError returns an error if message aborts registration. A
+// rejected nick is only an error once we've run out of alternates.
+func (b *IRCBot) registrationError(message *Message) error {
+	if message.Command == "ERROR" {
+		return fmt.Errorf("server closed the connection: %s", message.Trailing())
+	}
+
+	if nickErrors[message.Command] && b.tryNextNick() {
+		return nil
+	}
+
+	if reason, ok := registrationErrors[message.Command]; ok {
+		return fmt.Errorf("registration failed: %s (%s %s)", reason, message.Command, message.Trailing())
+	}
+
+	return nil
+}
+
+// handleWelcome records the nick the server registered us with.
+func (b *IRCBot) handleWelcome(message *Message) {
+	b.mu.Lock()
+	b.welcomed = true
+	b.nick = message.Param(0)
+	b.mu.Unlock()
+}
+
+// waitForRegistration reads until the server has welcomed us (001) and sent
+// the end of its MOTD (376, or 422 if it has none). Only then is it safe to
+// identify and join channels.
+func (b *IRCBot) waitForRegistration() error {
+	for {
+		message, err := b.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch message.Command {
+		case "PING":
+			b.sendRaw("PONG :" + message.Trailing())
+
+		case "CAP":
+			b.handleCap(message)
+
+		case "001":
+			b.handleWelcome(message)
+
+		case "005":
+			b.handleISupport(message)
+
+		case "376", "422":
+			b.mu.RLock()
+			welcomed := b.welcomed
+			b.mu.RUnlock()
+
+			if welcomed {
+				return nil
+			}
+
+		default:
+			if err := b.registrationError(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleISupport records the tokens the server advertises in RPL_ISUPPORT.
+func (b *IRCBot) handleISupport(message *Message) {
+	// The first parameter is our nick and the last is "are supported by
+	// this server".
+	if len(message.Params) < 3 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, token := range message.Params[1 : len(message.Params)-1] {
+		if strings.HasPrefix(token, "-") {
+			delete(b.isupport, strings.ToUpper(token[1:]))
+			continue
+		}
+		name, value, _ := strings.Cut(token, "=")
+		b.isupport[strings.ToUpper(name)] = value
+	}
+}
+
+// supports reports whether the server advertised the ISUPPORT token.
+func (b *IRCBot) supports(token string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.isupport[token]
+	return ok
+}
@@ -1,96 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
-  "errors"
-  "net/url"
 )
 
 type relayMessage struct {
 	Id          int
 	Timestamp   time.Time
 	FromUser    string
-  FromChannel string
+	FromChannel string
 	ToUser      string
 	Description string
 	URL         string
 }
 
-func saveRelayMessage(message relayMessage) error {
-	_, err := DB.Exec("INSERT INTO relay_messages (timestamp, from_user, from_channel, to_user, description, suggested_url) VALUES ($1, $2, $3, $4, $5, $6)", message.Timestamp, message.FromUser, message.FromChannel, message.ToUser, message.Description, message.URL)
+func getRelayMessageFromCommand(req *Request) (relayMessage, error) {
 
-	if err != nil {
-		return err
-	}
-
-	return nil
-
-}
-
-func markRelayMessageAsSent(id int) error {
-	_, err := DB.Exec("UPDATE relay_messages SET was_relayed = true WHERE id = $1", id)
-
-	if err != nil {
-		return err
+	// Relays sent to us privately aren't tied to a channel.
+	channel := req.Message.Param(0)
+	if !req.Bot.isChannel(channel) {
+		channel = ""
 	}
 
-	return nil
-}
-
-func getRelayMessageFromCommand(message string) (relayMessage, error) {
-
-	var fromUser, toUser, channel, description, url string
-
-	messageSlice := strings.Split(message, " ")
-
-	fromUser = strings.Split(messageSlice[0], "!")[0]
-	fromUser = strings.TrimLeft(fromUser, ":")
-	fromUser = strings.ToLower(fromUser)
-	toUser = strings.ToLower(messageSlice[4])
-	channel = strings.Split(messageSlice[2], "!")[0]
-	url = strings.Split(messageSlice[5], " ")[0]
-	description = strings.Join(messageSlice[6:], " ")
-	description = strings.TrimRight(description, "\r\n")
-
-  // TODO: I am not happy with the lack of error checking when assigning the values to the struct. I should probably add some checks here.
 	record := relayMessage{
 		Timestamp:   time.Now(),
-		FromUser:    fromUser,
-    FromChannel: channel,
-		ToUser:      toUser,
-		Description: description,
-		URL:         url,
-	}
-  
-  return record, nil
-}
-
-
-func getRelayMessages() ([]relayMessage, error) {
-	var messages []relayMessage
-
-	rows, err := DB.Query("SELECT id, timestamp, from_user, to_user, description, suggested_url FROM relay_messages WHERE was_relayed = false")
-
-	if err != nil {
-		return nil, err
+		FromUser:    strings.ToLower(req.Nick),
+		FromChannel: channel,
+		ToUser:      strings.ToLower(req.Args.String("user")),
+		Description: req.Args.String("description"),
+		URL:         req.Args.String("url"),
 	}
 
-	defer rows.Close()
-
-	for rows.Next() {
-		var message relayMessage
-		err := rows.Scan(&message.Id, &message.Timestamp, &message.FromUser, &message.ToUser, &message.Description, &message.URL)
-
-		if err != nil {
-			return nil, err
-		}
-
-		messages = append(messages, message)
-	}
-
-	return messages, nil
+	return record, nil
 }
 
 func isValidURL(inputURL string) bool {
@@ -107,70 +53,100 @@ func isValidURL(inputURL string) bool {
 	return true
 }
 
-func isUserInChannel(user string, channel string, message string) bool {
-	// TODO
-	return false
+func isUserInChannel(b *IRCBot, user string, channel string) bool {
+	if channel == "" {
+		return false
+	}
+	return b.IsInChannel(channel, user)
 }
 
-func addRelayMessage(message string) ([]string, error) {
+func addRelayMessage(ctx context.Context, req *Request) ([]string, error) {
 
 	var response []string
 
-  record, err := getRelayMessageFromCommand(message)
+	record, err := getRelayMessageFromCommand(req)
 
-  if err != nil {
-    response = append(response, "Error parsing message")
-    return response, err
-  }
+	if err != nil {
+		response = append(response, "Error parsing message")
+		return response, err
+	}
 
 	if !isValidURL(record.URL) {
-    response = append(response, "Invalid URL: " + record.URL)
-    return response, errors.New("Invalid URL: " + record.URL)
-  }
-	
-	if isUserInChannel(record.ToUser, record.FromChannel, message) {
+		response = append(response, "Invalid URL: "+record.URL)
+		return response, errors.New("Invalid URL: " + record.URL)
+	}
+
+	if isUserInChannel(req.Bot, record.ToUser, record.FromChannel) {
 		response = append(response, fmt.Sprintf("User %s is in the channel. Maybe they could just read this message? :D", record.ToUser))
 		return response, nil
 	}
 
 	fmt.Println("Saving message to database")
-	if err := saveRelayMessage(record); err != nil {
+	if err := DB.SaveRelayMessage(ctx, record); err != nil {
 		fmt.Println("Error saving message to database: ", err)
-		response = append(response, "Error saving message to database")
+		response = append(response, databaseError(err, "Error saving message to database"))
 		return response, err
 	}
 
-  if len(response) == 0 {
-    response = append(response, fmt.Sprintf("Message saved for %s. I will relay it the next time they are kicking around here.", record.ToUser))
-  }
+	if len(response) == 0 {
+		response = append(response, fmt.Sprintf("Message saved for %s. I will relay it the next time they are kicking around here.", record.ToUser))
+	}
 	return response, nil
 
 }
 
-func sendRelayMessage(toUser string) ([]string, error) {
+// sendRelayMessage returns the pending messages for toUser that were left in
+// channel, marking them as sent. Older messages with no channel recorded are
+// delivered anywhere. Users who get their messages privately aren't told
+// when there are none.
+func sendRelayMessage(ctx context.Context, toUser string, channel string, settings UserSettings) ([]string, error) {
 	var response []string
 
-	messages, err := getRelayMessages()
+	messages, err := DB.PendingRelayMessages(ctx)
 
 	if err != nil {
-		response = []string{"Error retrieving messages."}
+		response = []string{databaseError(err, "Error retrieving messages.")}
 		return response, err
 	}
 
 	for _, message := range messages {
-		if message.ToUser == toUser {
+		if message.ToUser == toUser && (message.FromChannel == "" || strings.EqualFold(message.FromChannel, channel)) {
 			response = append(response, fmt.Sprintf("%s: %s %s", message.FromUser, message.Description, message.URL))
-			if err := markRelayMessageAsSent(message.Id); err != nil {
-				response = []string{"Error marking message as sent."}
+			if err := DB.MarkRelayMessageSent(ctx, message.Id); err != nil {
+				response = []string{databaseError(err, "Error marking message as sent.")}
 				return response, err
 			}
 		}
 	}
 
-	if len(response) == 0 {
+	if len(response) == 0 && !settings.RelayPrivate {
 		response = append(response, "I have no pending messages for you.")
 	}
 
 	return response, nil
 
 }
+
+type relayURLCommand struct{ commandInfo }
+
+func (relayURLCommand) Run(ctx context.Context, req *Request) error {
+	resp, err := addRelayMessage(ctx, req)
+	req.Reply(resp...)
+	if err != nil {
+		return fmt.Errorf("adding relay message: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	commands.register(relayURLCommand{commandInfo{
+		name: "relay_url",
+		signature: Signature{Args: []ArgSpec{
+			{Name: "user", Kind: ArgNick},
+			{Name: "url", Kind: ArgURL},
+			{Name: "description", Optional: true, Rest: true},
+		}},
+		description: "Will post your message to the channel the next time the target user is active.",
+		permission:  RoleTrusted,
+	}})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errDatabaseDown is returned by the store while the database can't be
+// reached.
+var errDatabaseDown = errors.New("database unavailable")
+
+// DATABASE_DOWN_COOLDOWN is how long we wait before telling the same nick
+// again that the database is down, so nobody can make us talk by
+// repeating a command.
+const DATABASE_DOWN_COOLDOWN = 5 * time.Minute
+
+// nickLimiter lets something happen at most once per cooldown for each
+// nick.
+type nickLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (l *nickLimiter) allow(nick string, cooldown time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for n, last := range l.last {
+		if now.Sub(last) >= cooldown {
+			delete(l.last, n)
+		}
+	}
+
+	nick = strings.ToLower(nick)
+	if _, ok := l.last[nick]; ok {
+		return false
+	}
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+	}
+	l.last[nick] = now
+	return true
+}
+
+// databaseError is what to tell someone when a command fails because of
+// err: a polite explanation if the database is down, otherwise fallback.
+func databaseError(err error, fallback string) string {
+	if errors.Is(err, errDatabaseDown) {
+		return "Sorry, I can't reach my database right now. Try again in a little while."
+	}
+	return fallback
+}
+
+// resilientStore wraps a SQL store, putting a timeout on every call and
+// noticing when the database goes away. While it's down, calls fail
+// straight away with errDatabaseDown and it keeps trying to reconnect in
+// the background, so the bot can stay on IRC in the meantime.
+type resilientStore struct {
+	store       *sqlStore
+	driver      string
+	timeout     time.Duration
+	autoMigrate bool
+
+	mu       sync.Mutex
+	down     bool
+	migrated bool
+
+	// recovered gets a value each time the database comes back.
+	recovered chan struct{}
+	closed    chan struct{}
+}
+
+// openResilientStore opens the SQL database described by config, trying
+// to reach it and migrate it up to config.ConnectRetries more times before
+// carrying on without it.
+func openResilientStore(config DatabaseConfig) (*resilientStore, error) {
+	db, err := openSQLDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &resilientStore{
+		store:       &sqlStore{db: db},
+		driver:      config.Driver,
+		timeout:     config.QueryTimeout,
+		autoMigrate: config.AutoMigrate,
+		recovered:   make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = s.prepare()
+		if err == nil {
+			return s, nil
+		}
+		if !errors.Is(err, errDatabaseDown) {
+			db.Close()
+			return nil, err
+		}
+		if attempt >= config.ConnectRetries {
+			break
+		}
+
+		delay := backoffDelay(attempt)
+		fmt.Printf("Error connecting to database: %s. Retrying in %s\n", err, delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+
+	fmt.Println("Starting without the database:", err)
+	s.markDown()
+	return s, nil
+}
+
+// prepare checks the database can be reached and, the first time it can,
+// brings its schema up to date. If the bot's database user isn't allowed
+// to, or auto_migrate is off, it says what's missing and carries on with
+// the schema as it is.
+func (s *resilientStore) prepare() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	err := s.store.db.PingContext(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDatabaseDown, err)
+	}
+
+	s.mu.Lock()
+	migrated := s.migrated
+	s.mu.Unlock()
+	if migrated {
+		return nil
+	}
+
+	m, err := newMigrator(s.store.db, s.driver)
+	if err != nil {
+		return err
+	}
+
+	// Migrations can take a while, so they don't get the query timeout.
+	if s.autoMigrate {
+		_, err = m.Up(context.Background())
+		if isPermissionError(err) {
+			howTo := "Apply them as the owner of the tables with \"irc-bot migrate up\""
+			if s.driver == "postgres" {
+				howTo += " and give the bot access with \"irc-bot migrate grants\""
+			}
+			fmt.Printf("The database user isn't allowed to apply migrations (%s), so carrying on with the schema as it is. %s, or set database.auto_migrate to false (DB_AUTO_MIGRATE) to stop trying.\n", err, howTo)
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("migrating database: %w", err)
+		}
+	}
+
+	if pending, err := m.Pending(context.Background()); len(pending) > 0 {
+		var names []string
+		for _, migration := range pending {
+			names = append(names, fmt.Sprintf("%04d_%s", migration.version, migration.name))
+		}
+		fmt.Printf("Database migrations not applied: %s. Some features may not work until \"irc-bot migrate up\" is run as the owner of the tables.\n", strings.Join(names, ", "))
+	} else if err != nil {
+		fmt.Println("Error checking for database migrations:", err)
+	}
+
+	s.mu.Lock()
+	s.migrated = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *resilientStore) isDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down
+}
+
+// markDown notes that the database has gone and starts trying to get it
+// back.
+func (s *resilientStore) markDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		return
+	}
+	s.down = true
+	go s.reconnect()
+}
+
+// reconnect keeps trying the database, with the same backoff as IRC
+// reconnects, until it's back or the store is closed.
+func (s *resilientStore) reconnect() {
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(backoffDelay(attempt))
+		select {
+		case <-s.closed:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := s.prepare(); err != nil {
+			fmt.Println("Database still unavailable:", err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.down = false
+		s.mu.Unlock()
+
+		fmt.Println("Database connection restored")
+		select {
+		case s.recovered <- struct{}{}:
+		default:
+		}
+		return
+	}
+}
+
+// call runs fn with the query timeout applied. If it fails and the
+// database doesn't answer a ping either, the database is marked down.
+func (s *resilientStore) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.isDown() {
+		return errDatabaseDown
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := fn(callCtx)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+
+	// A failed query might just be a bad query, so only give up on the
+	// database if it's stopped answering.
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), s.timeout)
+	defer cancelPing()
+	if pingErr := s.store.db.PingContext(pingCtx); pingErr != nil {
+		fmt.Println("Lost connection to database:", pingErr)
+		s.markDown()
+		return fmt.Errorf("%w: %w", errDatabaseDown, err)
+	}
+	return err
+}
+
+func (s *resilientStore) Greetings(ctx context.Context) (greetings []Greeting, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		greetings, err = s.store.Greetings(ctx)
+		return err
+	})
+	return greetings, err
+}
+
+func (s *resilientStore) SaveRelayMessage(ctx context.Context, message relayMessage) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.SaveRelayMessage(ctx, message)
+	})
+}
+
+func (s *resilientStore) PendingRelayMessages(ctx context.Context) (messages []relayMessage, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		messages, err = s.store.PendingRelayMessages(ctx)
+		return err
+	})
+	return messages, err
+}
+
+func (s *resilientStore) MarkRelayMessageSent(ctx context.Context, id int) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.MarkRelayMessageSent(ctx, id)
+	})
+}
+
+func (s *resilientStore) UsersFor(ctx context.Context, nick, account string) (users []ircUser, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		users, err = s.store.UsersFor(ctx, nick, account)
+		return err
+	})
+	return users, err
+}
+
+func (s *resilientStore) AddUser(ctx context.Context, user ircUser) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.AddUser(ctx, user)
+	})
+}
+
+func (s *resilientStore) UpdateUser(ctx context.Context, user ircUser) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.UpdateUser(ctx, user)
+	})
+}
+
+func (s *resilientStore) ChannelSettings(ctx context.Context) (settings []ChannelSettings, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		settings, err = s.store.ChannelSettings(ctx)
+		return err
+	})
+	return settings, err
+}
+
+func (s *resilientStore) SaveChannelSettings(ctx context.Context, settings ChannelSettings) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.SaveChannelSettings(ctx, settings)
+	})
+}
+
+func (s *resilientStore) Close() error {
+	close(s.closed)
+	return s.store.Close()
+}
+
+// databaseRecovered signals each time the database comes back after being
+// unreachable. It never fires for the memory store.
+func databaseRecovered() <-chan struct{} {
+	if s, ok := DB.(*resilientStore); ok {
+		return s.recovered
+	}
+	return nil
+}
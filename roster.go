@@ -0,0 +1,263 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Member is someone in a channel, along with their status prefixes in
+// order of rank, e.g. "@+" for an op who also has voice.
+type Member struct {
+	Nick     string
+	Prefixes string
+}
+
+// roster keeps track of who is in each channel the bot is in, built from
+// NAMES replies and kept up to date from JOIN, PART, QUIT, KICK, NICK and
+// MODE. Channels and nicks are keyed in lower case.
+type roster struct {
+	mu       sync.RWMutex
+	channels map[string]map[string]*Member
+	// names collects 353 replies until the 366 that ends them.
+	names map[string]map[string]*Member
+}
+
+func newRoster() *roster {
+	return &roster{
+		channels: make(map[string]map[string]*Member),
+		names:    make(map[string]map[string]*Member),
+	}
+}
+
+// prefixModes returns the channel modes that grant status and their prefix
+// characters, from ISUPPORT PREFIX, e.g. "(ov)@+".
+func (b *IRCBot) prefixModes() (modes string, prefixes string) {
+	b.mu.RLock()
+	prefix, ok := b.isupport["PREFIX"]
+	b.mu.RUnlock()
+
+	if !ok || !strings.HasPrefix(prefix, "(") {
+		return "ov", "@+"
+	}
+
+	modes, prefixes, _ = strings.Cut(prefix[1:], ")")
+	return modes, prefixes
+}
+
+// IsInChannel reports whether nick is in channel, as far as we know.
+func (b *IRCBot) IsInChannel(channel, nick string) bool {
+	b.roster.mu.RLock()
+	defer b.roster.mu.RUnlock()
+
+	_, ok := b.roster.channels[strings.ToLower(channel)][strings.ToLower(nick)]
+	return ok
+}
+
+// sharesChannel reports whether nick is in any channel we're in, so we'll
+// see them quit or change nick.
+func (b *IRCBot) sharesChannel(nick string) bool {
+	b.roster.mu.RLock()
+	defer b.roster.mu.RUnlock()
+
+	key := strings.ToLower(nick)
+	for _, members := range b.roster.channels {
+		if _, ok := members[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ListMembers returns everyone in channel, sorted by nick.
+func (b *IRCBot) ListMembers(channel string) []Member {
+	b.roster.mu.RLock()
+	defer b.roster.mu.RUnlock()
+
+	var members []Member
+	for _, member := range b.roster.channels[strings.ToLower(channel)] {
+		members = append(members, *member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Nick) < strings.ToLower(members[j].Nick)
+	})
+	return members
+}
+
+// MemberPrefixes returns nick's status prefixes in channel, or an empty
+// string if they have none or aren't there.
+func (b *IRCBot) MemberPrefixes(channel, nick string) string {
+	b.roster.mu.RLock()
+	defer b.roster.mu.RUnlock()
+
+	if member, ok := b.roster.channels[strings.ToLower(channel)][strings.ToLower(nick)]; ok {
+		return member.Prefixes
+	}
+	return ""
+}
+
+// handleNames collects a RPL_NAMREPLY (353) line.
+func (b *IRCBot) handleNames(message *Message) {
+	// <me> <symbol> <channel> :<names>
+	channel := strings.ToLower(message.Param(2))
+	_, prefixes := b.prefixModes()
+
+	b.roster.mu.Lock()
+	defer b.roster.mu.Unlock()
+
+	names, ok := b.roster.names[channel]
+	if !ok {
+		names = make(map[string]*Member)
+		b.roster.names[channel] = names
+	}
+
+	for _, name := range strings.Fields(message.Trailing()) {
+		nick := strings.TrimLeft(name, prefixes)
+		// With userhost-in-names the nick comes as nick!user@host.
+		nick, _, _ = strings.Cut(nick, "!")
+		names[strings.ToLower(nick)] = &Member{
+			Nick:     nick,
+			Prefixes: name[:len(name)-len(strings.TrimLeft(name, prefixes))],
+		}
+	}
+}
+
+// handleEndOfNames replaces what we knew about a channel with the names
+// collected since the last RPL_ENDOFNAMES (366).
+func (b *IRCBot) handleEndOfNames(message *Message) {
+	channel := strings.ToLower(message.Param(1))
+
+	b.roster.mu.Lock()
+	defer b.roster.mu.Unlock()
+
+	if names, ok := b.roster.names[channel]; ok {
+		b.roster.channels[channel] = names
+		delete(b.roster.names, channel)
+	} else if _, ok := b.roster.channels[channel]; ok {
+		// An empty NAMES reply.
+		b.roster.channels[channel] = make(map[string]*Member)
+	}
+}
+
+// updateRoster applies a membership change from message to the roster.
+func (b *IRCBot) updateRoster(message *Message) {
+	nick := message.Nick()
+	key := strings.ToLower(nick)
+	me := b.isMe(nick)
+	kickedMe := message.Command == "KICK" && b.isMe(message.Param(1))
+
+	b.roster.mu.Lock()
+	defer b.roster.mu.Unlock()
+
+	switch message.Command {
+	case "JOIN":
+		channel := strings.ToLower(message.Param(0))
+		if me {
+			b.roster.channels[channel] = make(map[string]*Member)
+		}
+		if members, ok := b.roster.channels[channel]; ok {
+			members[key] = &Member{Nick: nick}
+		}
+
+	case "PART":
+		channel := strings.ToLower(message.Param(0))
+		if me {
+			delete(b.roster.channels, channel)
+		} else {
+			delete(b.roster.channels[channel], key)
+		}
+
+	case "KICK":
+		channel := strings.ToLower(message.Param(0))
+		if kickedMe {
+			delete(b.roster.channels, channel)
+		} else {
+			delete(b.roster.channels[channel], strings.ToLower(message.Param(1)))
+		}
+
+	case "QUIT":
+		for _, members := range b.roster.channels {
+			delete(members, key)
+		}
+
+	case "NICK":
+		newNick := message.Param(0)
+		for _, members := range b.roster.channels {
+			if member, ok := members[key]; ok {
+				delete(members, key)
+				member.Nick = newNick
+				members[strings.ToLower(newNick)] = member
+			}
+		}
+	}
+}
+
+// updatePrefixes applies a channel MODE change to members' status prefixes.
+// The roster lock must not be held, as this looks up ISUPPORT.
+func (b *IRCBot) updatePrefixes(message *Message) {
+	channel := strings.ToLower(message.Param(0))
+	if !b.isChannel(channel) || len(message.Params) < 2 {
+		return
+	}
+
+	modes, prefixes := b.prefixModes()
+
+	// CHANMODES=A,B,C,D: A and B always take a parameter, C only when set.
+	b.mu.RLock()
+	chanmodes := strings.Split(b.isupport["CHANMODES"], ",")
+	b.mu.RUnlock()
+	for len(chanmodes) < 4 {
+		chanmodes = append(chanmodes, "")
+	}
+
+	b.roster.mu.Lock()
+	defer b.roster.mu.Unlock()
+
+	members := b.roster.channels[channel]
+	args := message.Params[2:]
+	adding := true
+
+	for _, mode := range message.Params[1] {
+		switch {
+		case mode == '+':
+			adding = true
+		case mode == '-':
+			adding = false
+		case strings.ContainsRune(modes, mode):
+			if len(args) == 0 {
+				return
+			}
+			target := strings.ToLower(args[0])
+			args = args[1:]
+
+			member, ok := members[target]
+			i := strings.IndexRune(modes, mode)
+			if !ok || i >= len(prefixes) {
+				continue
+			}
+			prefix := prefixes[i]
+			member.Prefixes = strings.ReplaceAll(member.Prefixes, string(prefix), "")
+			if adding {
+				member.Prefixes = sortPrefixes(member.Prefixes+string(prefix), prefixes)
+			}
+		case strings.ContainsRune(chanmodes[0], mode), strings.ContainsRune(chanmodes[1], mode),
+			adding && strings.ContainsRune(chanmodes[2], mode):
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		}
+	}
+}
+
+// sortPrefixes orders held prefixes by rank, as given by the server's
+// PREFIX order.
+func sortPrefixes(held, order string) string {
+	var sorted strings.Builder
+	for _, prefix := range order {
+		if strings.ContainsRune(held, prefix) {
+			sorted.WriteRune(prefix)
+		}
+	}
+	return sorted.String()
+}
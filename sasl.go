@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SASLConfig holds the credentials used to authenticate during registration.
+type SASLConfig struct {
+	// Mechanism is either PLAIN or EXTERNAL. EXTERNAL relies on the client
+	// certificate presented over TLS.
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// errSASLFailed is returned when the server rejects our credentials, or
+// won't do SASL at all. It isn't worth retrying, unlike a dropped
+// connection.
+var errSASLFailed = errors.New("SASL authentication failed")
+
+// saslChunkSize is the largest AUTHENTICATE payload allowed per line.
+const saslChunkSize = 400
+
+// saslPayload builds the response sent after the server's "AUTHENTICATE +".
+func (s *SASLConfig) saslPayload() string {
+	if s.Mechanism == "EXTERNAL" {
+		return ""
+	}
+	return s.Username + "\x00" + s.Username + "\x00" + s.Password
+}
+
+// sendAuthenticate base64 encodes the payload and sends it in 400 byte
+// chunks, finishing with "+" when the payload is empty or an exact multiple
+// of the chunk size.
+func (b *IRCBot) sendAuthenticate(payload string) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	for len(encoded) >= saslChunkSize {
+		b.sendRaw("AUTHENTICATE " + encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+
+	if encoded == "" {
+		b.sendRaw("AUTHENTICATE +")
+	} else {
+		b.sendRaw("AUTHENTICATE " + encoded)
+	}
+}
+
+// authenticate performs SASL authentication. It must be called after the
+// sasl capability has been acknowledged and before CAP END.
+func (b *IRCBot) authenticate() error {
+	mechanism := strings.ToUpper(b.sasl.Mechanism)
+
+	b.mu.RLock()
+	offered, ok := b.availableCaps["sasl"]
+	b.mu.RUnlock()
+
+	// Servers may advertise the mechanisms they support as sasl=A,B.
+	if ok && offered != "" {
+		supported := false
+		for _, m := range strings.Split(offered, ",") {
+			if strings.EqualFold(m, mechanism) {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("server does not support SASL %s (offers %s)", mechanism, offered)
+		}
+	}
+
+	b.sendRaw("AUTHENTICATE " + mechanism)
+
+	for {
+		message, err := b.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch message.Command {
+		case "PING":
+			b.sendRaw("PONG :" + message.Trailing())
+
+		case "AUTHENTICATE":
+			if message.Param(0) == "+" {
+				b.sendAuthenticate(b.sasl.saslPayload())
+			}
+
+		case "900":
+			fmt.Println("Logged in:", message.Trailing())
+
+		case "903":
+			return nil
+
+		case "908":
+			fmt.Println("Server SASL mechanisms:", message.Param(1))
+
+		case "902", "904", "905", "906":
+			return fmt.Errorf("%w: %s %s %s", errSASLFailed, mechanism, message.Command, message.Trailing())
+
+		case "907":
+			// Already authenticated.
+			return nil
+
+		default:
+			if err := b.registrationError(message); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// UserSettings are the things each user can turn on or off for themselves.
+// They're stored as nullable columns in irc_users, where NULL means the
+// default.
+type UserSettings struct {
+	Greet        bool
+	RelayPrivate bool
+	AllowWeather bool
+}
+
+// defaultUserSettings apply to anyone who hasn't changed anything.
+var defaultUserSettings = UserSettings{
+	Greet:        true,
+	RelayPrivate: false,
+	AllowWeather: true,
+}
+
+// userSetting describes one setting for !set.
+type userSetting struct {
+	name        string
+	description string
+	// command, if set, is the command this setting allows or forbids.
+	command string
+	field   func(*UserSettings) *bool
+}
+
+var userSettings = []userSetting{
+	{
+		name:        "greet",
+		description: "greet you when you join a channel",
+		field:       func(s *UserSettings) *bool { return &s.Greet },
+	},
+	{
+		name:        "relay_private",
+		description: "pass on messages left for you in a private message rather than in the channel",
+		field:       func(s *UserSettings) *bool { return &s.RelayPrivate },
+	},
+	{
+		name:        "weather",
+		description: "let you use !weather (only admins can change this)",
+		command:     "weather",
+		field:       func(s *UserSettings) *bool { return &s.AllowWeather },
+	},
+}
+
+// findUserSetting looks up a setting by name.
+func findUserSetting(name string) (userSetting, bool) {
+	for _, setting := range userSettings {
+		if strings.EqualFold(setting.name, name) {
+			return setting, true
+		}
+	}
+	return userSetting{}, false
+}
+
+// settingForCommand returns the setting that allows or forbids command, if
+// there is one.
+func settingForCommand(command string) (userSetting, bool) {
+	for _, setting := range userSettings {
+		if setting.command != "" && strings.EqualFold(setting.command, command) {
+			return setting, true
+		}
+	}
+	return userSetting{}, false
+}
+
+// onOff formats a setting's value.
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+// parseOnOff reads a setting's value.
+func parseOnOff(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "on", "yes", "true", "1":
+		return true, true
+	case "off", "no", "false", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// settingsUser picks the irc_users row that holds the settings of nick,
+// logged in to account ("" if they aren't). A row for their account wins;
+// otherwise it's the row saved under their nick by !set.
+func settingsUser(users []ircUser, nick, account string) *ircUser {
+	if account != "" {
+		for i := range users {
+			if strings.EqualFold(users[i].Account, account) {
+				return &users[i]
+			}
+		}
+	}
+
+	for i := range users {
+		if users[i].Account == "" && users[i].Hostmask == "" && strings.EqualFold(users[i].Username, nick) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// getUserSettings returns the settings of nick, logged in to account ("" if
+// they aren't), or the defaults if they've never changed any.
+func getUserSettings(ctx context.Context, nick, account string) (UserSettings, error) {
+	users, err := DB.UsersFor(ctx, nick, account)
+	if err != nil {
+		return defaultUserSettings, err
+	}
+
+	if user := settingsUser(users, nick, account); user != nil {
+		return user.Settings, nil
+	}
+	return defaultUserSettings, nil
+}
+
+// saveUserSetting changes one setting for nick, logged in to account ("" if
+// they aren't). Settings are saved against the account when there is one,
+// so they follow the user between nicks.
+func saveUserSetting(ctx context.Context, nick, account string, setting userSetting, value bool) error {
+	users, err := DB.UsersFor(ctx, nick, account)
+	if err != nil {
+		return err
+	}
+
+	if user := settingsUser(users, nick, account); user != nil {
+		*setting.field(&user.Settings) = value
+		return DB.UpdateUser(ctx, *user)
+	}
+
+	user := ircUser{Username: strings.ToLower(nick), Settings: defaultUserSettings}
+	if account != "" {
+		user.Username = strings.ToLower(account)
+		user.Account = user.Username
+	}
+	*setting.field(&user.Settings) = value
+
+	return DB.AddUser(ctx, user)
+}
+
+// identitySettings returns the settings saved under identity, an account
+// name or a hostmask, for admins looking at someone else's.
+func identitySettings(ctx context.Context, identity string) (UserSettings, error) {
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		return defaultUserSettings, err
+	}
+	if user != nil {
+		return user.Settings, nil
+	}
+	return defaultUserSettings, nil
+}
+
+// saveIdentitySetting changes one setting for identity, an account name or
+// a hostmask, the same way roles are granted.
+func saveIdentitySetting(ctx context.Context, identity string, setting userSetting, value bool) error {
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	if user != nil {
+		*setting.field(&user.Settings) = value
+		return DB.UpdateUser(ctx, *user)
+	}
+
+	newUser := ircUser{Username: identity, Settings: defaultUserSettings}
+	if isHostmask(identity) {
+		newUser.Hostmask = identity
+	} else {
+		newUser.Account = strings.ToLower(identity)
+	}
+	*setting.field(&newUser.Settings) = value
+
+	return DB.AddUser(ctx, newUser)
+}
+
+// commandAllowed reports whether setting lets someone with prefix, logged
+// in to account, use its command. Unlike other settings it's a permission,
+// so like a role it only comes from a matching hostmask or the account
+// services vouch for, never from the sender's nick.
+func commandAllowed(ctx context.Context, prefix Prefix, account string, setting userSetting) (bool, error) {
+	defaults := defaultUserSettings
+	allowed := *setting.field(&defaults)
+
+	users, err := DB.UsersFor(ctx, "", account)
+	if err != nil {
+		return allowed, err
+	}
+
+	for _, user := range users {
+		if *setting.field(&user.Settings) != allowed && user.matches(prefix, account) {
+			return !allowed, nil
+		}
+	}
+
+	return allowed, nil
+}
+
+// knownAccount returns the account the sender of message is logged in to as
+// far as we already know, without asking the server. Settings are
+// preferences rather than permissions, so this is good enough for them.
+func (b *IRCBot) knownAccount(message *Message) string {
+	if b.HasCap("account-tag") {
+		return message.Tags["account"]
+	}
+
+	b.accounts.mu.Lock()
+	defer b.accounts.mu.Unlock()
+	return b.accounts.accounts[strings.ToLower(message.Nick())].account
+}
+
+type setCommand struct{ commandInfo }
+
+func (setCommand) Run(ctx context.Context, req *Request) error {
+	nick, account := req.Nick, req.Account
+	load := func() (UserSettings, error) {
+		return getUserSettings(ctx, nick, account)
+	}
+	save := func(setting userSetting, value bool) error {
+		return saveUserSetting(ctx, nick, account, setting, value)
+	}
+
+	// Admins can change someone else's settings, e.g. to take !weather
+	// away. They're saved under an account or hostmask, like roles.
+	if req.Args.Has("user") {
+		if req.Role < RoleAdmin {
+			req.Reply("Sorry, only admins can change other people's settings.")
+			return nil
+		}
+		nick = req.Args.String("user")
+		load = func() (UserSettings, error) {
+			return identitySettings(ctx, nick)
+		}
+		save = func(setting userSetting, value bool) error {
+			return saveIdentitySetting(ctx, nick, setting, value)
+		}
+	}
+
+	settings, err := load()
+	if err != nil {
+		req.Reply(databaseError(err, "Error reading settings from database"))
+		return err
+	}
+
+	if !req.Args.Has("setting") {
+		var parts []string
+		for _, setting := range userSettings {
+			parts = append(parts, fmt.Sprintf("%s: %s", setting.name, onOff(*setting.field(&settings))))
+		}
+		req.Reply("Settings for " + nick + ": " + strings.Join(parts, ", "))
+		return nil
+	}
+
+	setting, ok := findUserSetting(req.Args.String("setting"))
+	if !ok {
+		var lines []string
+		for _, setting := range userSettings {
+			lines = append(lines, fmt.Sprintf("%s - %s", setting.name, setting.description))
+		}
+		return usageErrorf("there's no setting called %s. Settings are: %s", req.Args.String("setting"), strings.Join(lines, "; "))
+	}
+
+	if !req.Args.Has("value") {
+		req.Reply(fmt.Sprintf("%s is %s for %s (%s).", setting.name, onOff(*setting.field(&settings)), nick, setting.description))
+		return nil
+	}
+
+	// Settings that allow a command are permissions, so only admins can
+	// change them.
+	if setting.command != "" && req.Role < RoleAdmin {
+		req.Reply(fmt.Sprintf("Sorry, only admins can change %s.", setting.name))
+		return nil
+	}
+
+	value, ok := parseOnOff(req.Args.String("value"))
+	if !ok {
+		return usageErrorf("value must be on or off")
+	}
+
+	if err := save(setting, value); err != nil {
+		req.Reply(databaseError(err, "Error saving setting to database"))
+		return err
+	}
+
+	req.Reply(fmt.Sprintf("%s is now %s for %s.", setting.name, onOff(value), nick))
+	return nil
+}
+
+func init() {
+	commands.register(setCommand{commandInfo{
+		name: "set",
+		signature: Signature{
+			Args: []ArgSpec{
+				{Name: "setting", Optional: true},
+				{Name: "value", Optional: true},
+			},
+			Flags: []FlagSpec{
+				{Name: "user"},
+			},
+		},
+		description: "Shows or changes your settings, e.g. '!set greet off'. Settings are greet, relay_private and weather. Admins can change someone else's with --user <account or nick!user@host mask>.",
+		permission:  RoleEveryone,
+	}})
+}
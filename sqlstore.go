@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore is a Store backed by a SQL database. The queries are written to
+// work on both Postgres and SQLite.
+type sqlStore struct {
+	db *sql.DB
+}
+
+// openSQLDatabase opens the Postgres or SQLite database described by
+// config, with its connection pool tuned. Nothing is connected until the
+// database is first used.
+func openSQLDatabase(config DatabaseConfig) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+
+	if config.Driver == "sqlite" {
+		// Wait for other connections to finish writing rather than
+		// failing straight away.
+		db, err = sql.Open("sqlite", config.Path+"?_pragma=busy_timeout(10000)")
+		if err != nil {
+			return nil, err
+		}
+
+		// SQLite only lets one connection write at a time.
+		db.SetMaxOpenConns(1)
+	} else {
+		db, err = sql.Open("postgres", "user="+config.User+" dbname="+config.Name+" password="+config.Password+" host="+config.Host+" port="+config.Port+" sslmode=disable")
+		if err != nil {
+			return nil, err
+		}
+
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	return db, nil
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *sqlStore) Greetings(ctx context.Context) ([]Greeting, error) {
+	var greetings []Greeting
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, first_word, body, COALESCE(language, '') FROM greetings")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var greeting Greeting
+		err := rows.Scan(&greeting.ID, &greeting.FirstWord, &greeting.Body, &greeting.Language)
+
+		if err != nil {
+			return nil, err
+		}
+		greetings = append(greetings, greeting)
+	}
+
+	return greetings, rows.Err()
+}
+
+func (s *sqlStore) SaveRelayMessage(ctx context.Context, message relayMessage) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO relay_messages (timestamp, from_user, from_channel, to_user, description, suggested_url) VALUES ($1, $2, $3, $4, $5, $6)", message.Timestamp, message.FromUser, message.FromChannel, message.ToUser, message.Description, message.URL)
+	return err
+}
+
+func (s *sqlStore) PendingRelayMessages(ctx context.Context) ([]relayMessage, error) {
+	var messages []relayMessage
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, timestamp, from_user, COALESCE(from_channel, ''), to_user, description, suggested_url FROM relay_messages WHERE was_relayed = false")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var message relayMessage
+		err := rows.Scan(&message.Id, &message.Timestamp, &message.FromUser, &message.FromChannel, &message.ToUser, &message.Description, &message.URL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+func (s *sqlStore) MarkRelayMessageSent(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE relay_messages SET was_relayed = true WHERE id = $1", id)
+	return err
+}
+
+func (s *sqlStore) UsersFor(ctx context.Context, nick, account string) ([]ircUser, error) {
+	var users []ircUser
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, created_on, COALESCE(username, ''), COALESCE(account, ''), COALESCE(hostmask, ''), COALESCE(role, ''), COALESCE(greet, $1), COALESCE(relay_private, $2), COALESCE(allow_weather, $3) FROM irc_users WHERE hostmask IS NOT NULL OR lower(account) = $4 OR (account IS NULL AND lower(username) = $5)", defaultUserSettings.Greet, defaultUserSettings.RelayPrivate, defaultUserSettings.AllowWeather, strings.ToLower(account), strings.ToLower(nick))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user ircUser
+		var createdOn sql.NullTime
+		var role string
+		err := rows.Scan(&user.ID, &createdOn, &user.Username, &user.Account, &user.Hostmask, &role, &user.Settings.Greet, &user.Settings.RelayPrivate, &user.Settings.AllowWeather)
+
+		if err != nil {
+			return nil, err
+		}
+
+		user.CreatedOn = createdOn.Time
+		user.Role, _ = parseRole(role)
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// userRole is how a role is stored: NULL for RoleEveryone.
+func userRole(role Role) sql.NullString {
+	if role == RoleEveryone {
+		return sql.NullString{}
+	}
+	return nullString(role.String())
+}
+
+func (s *sqlStore) AddUser(ctx context.Context, user ircUser) error {
+	if user.CreatedOn.IsZero() {
+		user.CreatedOn = time.Now()
+	}
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO irc_users (created_on, username, account, hostmask, role, greet, relay_private, allow_weather) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", user.CreatedOn, user.Username, nullString(user.Account), nullString(user.Hostmask), userRole(user.Role), user.Settings.Greet, user.Settings.RelayPrivate, user.Settings.AllowWeather)
+	return err
+}
+
+func (s *sqlStore) UpdateUser(ctx context.Context, user ircUser) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE irc_users SET role = $1, greet = $2, relay_private = $3, allow_weather = $4 WHERE id = $5", userRole(user.Role), user.Settings.Greet, user.Settings.RelayPrivate, user.Settings.AllowWeather, user.ID)
+	return err
+}
+
+func (s *sqlStore) ChannelSettings(ctx context.Context) ([]ChannelSettings, error) {
+	var settings []ChannelSettings
+
+	rows, err := s.db.QueryContext(ctx, "SELECT channel, COALESCE(greet, true), COALESCE(prefixes, ''), COALESCE(disabled_commands, ''), COALESCE(language, '') FROM channel_settings")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel ChannelSettings
+		var prefixes, disabled string
+		err := rows.Scan(&channel.Channel, &channel.Greet, &prefixes, &disabled, &channel.Language)
+
+		if err != nil {
+			return nil, err
+		}
+
+		channel.Prefixes = strings.Fields(prefixes)
+		channel.Disabled = strings.Fields(strings.ReplaceAll(disabled, ",", " "))
+		settings = append(settings, channel)
+	}
+
+	return settings, rows.Err()
+}
+
+func (s *sqlStore) SaveChannelSettings(ctx context.Context, settings ChannelSettings) error {
+	prefixes := nullString(strings.Join(settings.Prefixes, " "))
+	disabled := nullString(strings.Join(settings.Disabled, ","))
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO channel_settings (channel, greet, prefixes, disabled_commands, language) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (channel) DO UPDATE SET greet = EXCLUDED.greet, prefixes = EXCLUDED.prefixes, disabled_commands = EXCLUDED.disabled_commands, language = EXCLUDED.language", strings.ToLower(settings.Channel), settings.Greet, prefixes, disabled, nullString(settings.Language))
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Store is where the bot keeps everything that outlives a connection:
+// greetings, relay messages, users and channel settings. There's a SQL
+// store for Postgres and SQLite, and an in-memory one for trying the bot
+// out.
+type Store interface {
+	Greetings(ctx context.Context) ([]Greeting, error)
+
+	SaveRelayMessage(ctx context.Context, message relayMessage) error
+	// PendingRelayMessages returns the relay messages not yet passed on.
+	PendingRelayMessages(ctx context.Context) ([]relayMessage, error)
+	MarkRelayMessageSent(ctx context.Context, id int) error
+
+	// UsersFor returns the users that could be someone with nick, logged
+	// in to account ("" if they aren't): the ones under that account, the
+	// settings saved under the bare nick, and everyone granted by hostmask,
+	// which have to be matched one by one. Either can be "" to skip it.
+	UsersFor(ctx context.Context, nick, account string) ([]ircUser, error)
+	AddUser(ctx context.Context, user ircUser) error
+	// UpdateUser saves user's role and settings, by ID.
+	UpdateUser(ctx context.Context, user ircUser) error
+
+	ChannelSettings(ctx context.Context) ([]ChannelSettings, error)
+	// SaveChannelSettings replaces whatever settings the channel had.
+	SaveChannelSettings(ctx context.Context, settings ChannelSettings) error
+
+	Close() error
+}
+
+// DB is the store the bot uses, opened by OpenDatabase.
+var DB Store
+
+// OpenDatabase opens the store described by config, applying any
+// migrations it's missing. A SQL database that can't be reached doesn't
+// stop the bot starting; it keeps trying in the background.
+func OpenDatabase(config DatabaseConfig) error {
+	var err error
+
+	switch config.Driver {
+	case "postgres", "sqlite":
+		var store *resilientStore
+		store, err = openResilientStore(config)
+		if err == nil {
+			DB = store
+		}
+	case "memory":
+		fmt.Println("Using an in-memory database; nothing will be saved")
+		DB = newMemoryStore()
+	default:
+		err = fmt.Errorf("unknown database driver %q", config.Driver)
+	}
+
+	return err
+}
+
+func CloseDatabase() error {
+	return DB.Close()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig describes how the bot secures its connection to the server.
+// Certificates are verified against the system roots unless CAFile or
+// Fingerprint say otherwise.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of extra CAs to trust, for networks using
+	// their own CA.
+	CAFile string
+	// Fingerprint pins the server certificate by its SHA-256 fingerprint,
+	// in hex with or without colons. When set, the pin replaces CA
+	// verification, so self-signed certificates work.
+	Fingerprint string
+	// CertFile and KeyFile are the client certificate presented for CertFP
+	// and SASL EXTERNAL.
+	CertFile string
+	KeyFile  string
+}
+
+// normaliseFingerprint strips colons and spaces and lowercases a hex
+// fingerprint so it can be compared.
+func normaliseFingerprint(fingerprint string) string {
+	fingerprint = strings.ReplaceAll(fingerprint, ":", "")
+	fingerprint = strings.ReplaceAll(fingerprint, " ", "")
+	return strings.ToLower(fingerprint)
+}
+
+// build turns the configuration into a tls.Config for connecting to server.
+func (c *TLSConfig) build(server string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: server,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.Fingerprint != "" {
+		want := normaliseFingerprint(c.Fingerprint)
+
+		// Go's own verification has to be turned off for a pin to accept a
+		// self-signed certificate, so we check the leaf ourselves.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if got := hex.EncodeToString(sum[:]); got != want {
+				return fmt.Errorf("server certificate fingerprint %s does not match the pinned one", got)
+			}
+			return nil
+		}
+	}
+
+	if c.CertFile != "" {
+		keyFile := c.KeyFile
+		if keyFile == "" {
+			// The key is often bundled in the same PEM file.
+			keyFile = c.CertFile
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role is how far the bot trusts someone. Each role can do everything the
+// ones below it can.
+type Role int
+
+const (
+	RoleEveryone Role = iota
+	RoleTrusted
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleEveryone: "everyone",
+	RoleTrusted:  "trusted",
+	RoleAdmin:    "admin",
+	RoleOwner:    "owner",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// parseRole turns a role name back into a Role.
+func parseRole(name string) (Role, bool) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, true
+		}
+	}
+	return RoleEveryone, false
+}
+
+// ircUser is a row in irc_users. Trust is tied to a services account or a
+// hostmask pattern, never to a bare nick, since anyone can take a nick.
+type ircUser struct {
+	ID        int
+	CreatedOn time.Time
+	Username  string
+	Account   string
+	Hostmask  string
+	Role      Role
+	Settings  UserSettings
+}
+
+// isHostmask reports whether an identity given to !grant is a hostmask
+// pattern rather than an account name.
+func isHostmask(identity string) bool {
+	return strings.ContainsAny(identity, "!@*?")
+}
+
+// matchMask reports whether mask matches pattern, where * matches any run
+// of characters and ? any single character. IRC masks are case
+// insensitive.
+func matchMask(pattern, mask string) bool {
+	pattern = strings.ToLower(pattern)
+	mask = strings.ToLower(mask)
+
+	// Classic wildcard matching, backtracking to the last * on a mismatch.
+	p, m := 0, 0
+	star, starMatch := -1, 0
+	for m < len(mask) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == mask[m]):
+			p++
+			m++
+		case p < len(pattern) && pattern[p] == '*':
+			star, starMatch = p, m
+			p++
+		case star != -1:
+			p = star + 1
+			starMatch++
+			m = starMatch
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matches reports whether someone with the given prefix and services
+// account is this user.
+func (u ircUser) matches(prefix Prefix, account string) bool {
+	if u.Account != "" && account != "" && strings.EqualFold(u.Account, account) {
+		return true
+	}
+	if u.Hostmask != "" && prefix.User != "" && matchMask(u.Hostmask, prefix.String()) {
+		return true
+	}
+	return false
+}
+
+// parseOwners reads the comma separated BOT_OWNERS list of accounts and
+// hostmasks. Owners always have every permission, which is how the first
+// owner gets to grant roles to everyone else.
+func parseOwners(list string) []ircUser {
+	var owners []ircUser
+
+	for _, identity := range strings.Split(list, ",") {
+		identity = strings.TrimSpace(identity)
+		switch {
+		case identity == "":
+		case isHostmask(identity):
+			owners = append(owners, ircUser{Username: identity, Hostmask: identity, Role: RoleOwner})
+		default:
+			owners = append(owners, ircUser{Username: identity, Account: identity, Role: RoleOwner})
+		}
+	}
+
+	return owners
+}
+
+// findUser returns the user granted under identity, an account name or a
+// hostmask, if there is one.
+func findUser(ctx context.Context, identity string) (*ircUser, error) {
+	account := identity
+	if isHostmask(identity) {
+		account = ""
+	}
+
+	users, err := DB.UsersFor(ctx, "", account)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if strings.EqualFold(user.Account, identity) || strings.EqualFold(user.Hostmask, identity) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+// grantRole gives identity, an account name or a hostmask, the role.
+func grantRole(ctx context.Context, identity string, role Role) error {
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	if user != nil {
+		user.Role = role
+		return DB.UpdateUser(ctx, *user)
+	}
+
+	newUser := ircUser{Username: identity, Role: role, Settings: defaultUserSettings}
+	if isHostmask(identity) {
+		newUser.Hostmask = identity
+	} else {
+		newUser.Account = strings.ToLower(identity)
+	}
+
+	return DB.AddUser(ctx, newUser)
+}
+
+// revokeRole takes away whatever role identity was given.
+func revokeRole(ctx context.Context, identity string) error {
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("%s has no role", identity)
+	}
+
+	user.Role = RoleEveryone
+	return DB.UpdateUser(ctx, *user)
+}
+
+// roleFor works out the highest role held by someone with prefix, logged
+// in to account ("" if they aren't, or we couldn't tell), from the
+// configured owners and the irc_users table.
+func (b *IRCBot) roleFor(ctx context.Context, prefix Prefix, account string) (Role, error) {
+	b.mu.RLock()
+	owners := b.owners
+	b.mu.RUnlock()
+
+	users, err := DB.UsersFor(ctx, "", account)
+	users = append(append([]ircUser(nil), owners...), users...)
+
+	return bestRole(users, prefix, account), err
+}
+
+// bestRole returns the highest role among the users matching prefix and
+// account.
+func bestRole(users []ircUser, prefix Prefix, account string) Role {
+	role := RoleEveryone
+	for _, user := range users {
+		if user.Role > role && user.matches(prefix, account) {
+			role = user.Role
+		}
+	}
+	return role
+}
+
+type grantCommand struct{ commandInfo }
+
+func (grantCommand) Run(ctx context.Context, req *Request) error {
+	identity := req.Args.String("identity")
+
+	role, ok := parseRole(req.Args.String("role"))
+	if !ok || role == RoleEveryone {
+		return usageErrorf("role must be one of trusted, admin or owner")
+	}
+
+	// Only owners can hand out roles as high as their own.
+	if role >= req.Role && req.Role != RoleOwner {
+		req.Reply(fmt.Sprintf("Sorry, you can't grant %s.", role))
+		return nil
+	}
+
+	// Nor can they change the role of anyone who ranks with or above them.
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		req.Reply(databaseError(err, "Error reading roles from database"))
+		return err
+	}
+	if user != nil && user.Role >= req.Role && req.Role != RoleOwner {
+		req.Reply(fmt.Sprintf("Sorry, you can't change the role of %s, who is %s.", identity, user.Role))
+		return nil
+	}
+
+	if err := grantRole(ctx, identity, role); err != nil {
+		req.Reply(databaseError(err, "Error saving role to database"))
+		return err
+	}
+
+	req.Reply(fmt.Sprintf("%s is now %s.", identity, role))
+	return nil
+}
+
+type revokeCommand struct{ commandInfo }
+
+func (revokeCommand) Run(ctx context.Context, req *Request) error {
+	identity := req.Args.String("identity")
+
+	user, err := findUser(ctx, identity)
+	if err != nil {
+		req.Reply(databaseError(err, "Error reading roles from database"))
+		return err
+	}
+	if user == nil || user.Role == RoleEveryone {
+		req.Reply(fmt.Sprintf("%s doesn't have a role.", identity))
+		return nil
+	}
+
+	if user.Role >= req.Role && req.Role != RoleOwner {
+		req.Reply(fmt.Sprintf("Sorry, you can't revoke %s.", user.Role))
+		return nil
+	}
+
+	if err := revokeRole(ctx, identity); err != nil {
+		req.Reply(databaseError(err, "Error saving role to database"))
+		return err
+	}
+
+	req.Reply(fmt.Sprintf("%s is no longer %s.", identity, user.Role))
+	return nil
+}
+
+type whoamiCommand struct{ commandInfo }
+
+func (whoamiCommand) Run(ctx context.Context, req *Request) error {
+	account := req.Account
+	if account == "" {
+		account = "none"
+	}
+
+	req.Reply(fmt.Sprintf("You are %s (account %s), role: %s.", req.Message.Prefix.String(), account, req.Role))
+	return nil
+}
+
+func init() {
+	identity := ArgSpec{Name: "identity"}
+
+	commands.register(grantCommand{commandInfo{
+		name: "grant",
+		signature: Signature{Args: []ArgSpec{
+			identity,
+			{Name: "role"},
+		}},
+		description: "Gives a services account, or a nick!user@host mask, a role: trusted, admin or owner.",
+		permission:  RoleAdmin,
+	}})
+	commands.register(revokeCommand{commandInfo{
+		name:        "revoke",
+		signature:   Signature{Args: []ArgSpec{identity}},
+		description: "Takes away the role given to a services account or mask.",
+		permission:  RoleAdmin,
+	}})
+	commands.register(whoamiCommand{commandInfo{
+		name:        "whoami",
+		description: "Tells you who the bot thinks you are, and what you're allowed to do.",
+		permission:  RoleEveryone,
+	}})
+}
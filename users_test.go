@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern, mask string
+		want          bool
+	}{
+		{"*!*@example.com", "alice!al@example.com", true},
+		{"*!*@example.com", "alice!al@evil.example.com", false},
+		{"*!*@*.example.com", "alice!al@evil.example.com", true},
+		{"alice!*@*", "ALICE!al@host", true},
+		{"alice!*@*", "alicex!al@host", false},
+		{"bo?!*@*", "bob!b@host", true},
+		{"bo?!*@*", "bo!b@host", false},
+		{"*", "anyone!at@all", true},
+		{"*!*@*", "", false},
+		{"", "", true},
+		{"a*b*c", "aXXbYYbZZc", true},
+		{"a*b*c", "aXXbYYbZZ", false},
+	}
+
+	for _, test := range tests {
+		if got := matchMask(test.pattern, test.mask); got != test.want {
+			t.Errorf("matchMask(%q, %q) = %v, want %v", test.pattern, test.mask, got, test.want)
+		}
+	}
+}
+
+func TestUserMatches(t *testing.T) {
+	alice := Prefix{Name: "alice", User: "al", Host: "users.example.com"}
+	nickOnly := Prefix{Name: "alice"}
+
+	tests := []struct {
+		user    ircUser
+		prefix  Prefix
+		account string
+		want    bool
+	}{
+		{ircUser{Account: "alice"}, alice, "Alice", true},
+		{ircUser{Account: "alice"}, alice, "", false},
+		{ircUser{Account: "alice"}, alice, "mallory", false},
+		{ircUser{Hostmask: "*!*@users.example.com"}, alice, "", true},
+		{ircUser{Hostmask: "*!*@other.example.com"}, alice, "alice", false},
+		// A prefix without user@host, e.g. from a server, never matches a
+		// hostmask.
+		{ircUser{Hostmask: "alice*"}, nickOnly, "", false},
+		// Nor does a bare nick give anyone an identity.
+		{ircUser{Username: "alice"}, alice, "", false},
+	}
+
+	for _, test := range tests {
+		if got := test.user.matches(test.prefix, test.account); got != test.want {
+			t.Errorf("%+v.matches(%q, %q) = %v, want %v", test.user, test.prefix.String(), test.account, got, test.want)
+		}
+	}
+}
+
+func TestBestRole(t *testing.T) {
+	users := []ircUser{
+		{Hostmask: "*!*@users.example.com", Role: RoleTrusted},
+		{Account: "alice", Role: RoleAdmin},
+		{Account: "bob", Role: RoleOwner},
+	}
+	prefix := Prefix{Name: "alice", User: "al", Host: "users.example.com"}
+
+	tests := []struct {
+		account string
+		want    Role
+	}{
+		{"", RoleTrusted},
+		{"alice", RoleAdmin},
+		{"carol", RoleTrusted},
+	}
+
+	for _, test := range tests {
+		if got := bestRole(users, prefix, test.account); got != test.want {
+			t.Errorf("bestRole(account %q) = %s, want %s", test.account, got, test.want)
+		}
+	}
+
+	if got := bestRole(users, Prefix{Name: "x", User: "x", Host: "elsewhere"}, ""); got != RoleEveryone {
+		t.Errorf("bestRole for a stranger = %s, want %s", got, RoleEveryone)
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleEveryone, RoleTrusted, RoleAdmin, RoleOwner} {
+		if got, ok := parseRole(role.String()); !ok || got != role {
+			t.Errorf("parseRole(%q) = %s, %v", role.String(), got, ok)
+		}
+	}
+	if got, ok := parseRole("Admin"); !ok || got != RoleAdmin {
+		t.Errorf("parseRole(%q) = %s, %v", "Admin", got, ok)
+	}
+	if _, ok := parseRole("superuser"); ok {
+		t.Errorf("parseRole(%q) succeeded", "superuser")
+	}
+}
+
+func TestParseOwners(t *testing.T) {
+	got := parseOwners(" alice, *!*@my.cloak ,,")
+	want := []ircUser{
+		{Username: "alice", Account: "alice", Role: RoleOwner},
+		{Username: "*!*@my.cloak", Hostmask: "*!*@my.cloak", Role: RoleOwner},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOwners = %+v, want %+v", got, want)
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"strings"
 
 	"github.com/jlaffaye/ftp"
@@ -89,22 +89,33 @@ func parseXML(xmlData string) (*Product, error) {
 	return &product, err
 }
 
-func getWeather() (string, error) {
+func getWeather(ctx context.Context, config WeatherConfig) (string, error) {
 	// FTP server details
-	ftpServer := os.Getenv("FTP_SERVER")
-	ftpUser := os.Getenv("FTP_USER")
-	ftpPassword := os.Getenv("FTP_PASSWORD")
-	ftpFilePath := os.Getenv("FTP_FILE_PATH") + "/" + os.Getenv("FTP_FILE_NAME")
+	ftpServer := config.FTPServer
+	ftpUser := config.FTPUser
+	ftpPassword := config.FTPPassword
+	ftpFilePath := config.FTPFilePath + "/" + config.FTPFileName
 
 	fmt.Println("Retrieving data from " + ftpServer + ftpFilePath + "...")
 
 	// Connect to FTP server
-	conn, err := ftp.Dial(fmt.Sprintf("%s:%d", ftpServer, 21))
+	conn, err := ftp.Dial(fmt.Sprintf("%s:%d", ftpServer, 21), ftp.DialWithContext(ctx))
 	if err != nil {
 		return "", err
 	}
 	defer conn.Quit()
 
+	// Abandon the download if the command is cancelled or times out.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Quit()
+		case <-finished:
+		}
+	}()
+
 	// Login to the FTP server
 	err = conn.Login(ftpUser, ftpPassword)
 	if err != nil {
@@ -121,15 +132,18 @@ func getWeather() (string, error) {
 	// Read the content of the file
 	xmlBytes, err := ioutil.ReadAll(r)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", err
 	}
 
 	return string(xmlBytes), nil
 }
 
-func handleWeather(location string) ([]string, error) {
+func handleWeather(ctx context.Context, config WeatherConfig, location string, days int) ([]string, error) {
 	var result []string
-	xmlData, err := getWeather()
+	xmlData, err := getWeather(ctx, config)
 
 	if err != nil {
 		return result, err
@@ -143,36 +157,41 @@ func handleWeather(location string) ([]string, error) {
 		var forecast_area string
 
 		if area.Type == "location" && area.Description == location {
-      forecast_area = fmt.Sprintf("Area: %s (%s)\n", area.Description, area.Type)
+      forecast_area = fmt.Sprintf("Area: %s (%s)", area.Description, area.Type)
 			result = append(result, forecast_area)
 			var forecast_period, temp_min, temp_max, precipitation_range, precis, chance_of_rain string
 
-      for _, period := range area.ForecastPeriod[0:2] {
-				forecast_period = fmt.Sprintf("Period: %s to %s\n", period.StartTimeLocal, period.EndTimeLocal)
+			periods := area.ForecastPeriod
+			if len(periods) > days {
+				periods = periods[:days]
+			}
+
+			for _, period := range periods {
+				forecast_period = fmt.Sprintf("Period: %s to %s", period.StartTimeLocal, period.EndTimeLocal)
 				result = append(result, forecast_period)
 
 				for _, elem := range period.Element {
 					if elem.Type == "air_temperature_minimum" {
-						temp_min = fmt.Sprintf("Minimum temperature of: %s %s\n", elem.Text, elem.Units)
+						temp_min = fmt.Sprintf("Minimum temperature of: %s %s", elem.Text, elem.Units)
 						result = append(result, temp_min)
 					}
 					if elem.Type == "air_temperature_maximum" {
-						temp_max = fmt.Sprintf("Maximum temperature of: %s %s\n", elem.Text, elem.Units)
+						temp_max = fmt.Sprintf("Maximum temperature of: %s %s", elem.Text, elem.Units)
 						result = append(result, temp_max)
 					}
 					if elem.Type == "precipitation_range" {
-						precipitation_range = fmt.Sprintf("How much will it rain? %s %s\n", elem.Text, elem.Units)
+						precipitation_range = fmt.Sprintf("How much will it rain? %s %s", elem.Text, elem.Units)
 						result = append(result, precipitation_range)
 					}
 				}
 
 				for _, text := range period.Text {
 					if text.Type == "precis" {
-						precis = fmt.Sprintf("The forecast: %s\n", text.Text)
+						precis = fmt.Sprintf("The forecast: %s", text.Text)
 						result = append(result, precis)
 					}
 					if text.Type == "probability_of_precipitation" {
-						chance_of_rain = fmt.Sprintf("Chance of rain: %s\n", text.Text)
+						chance_of_rain = fmt.Sprintf("Chance of rain: %s", text.Text)
 						result = append(result, chance_of_rain)
 					}
 				}
@@ -187,3 +206,40 @@ func handleWeather(location string) ([]string, error) {
 	fmt.Println(result)
 	return result, nil
 }
+
+type weatherCommand struct{ commandInfo }
+
+func (weatherCommand) Run(ctx context.Context, req *Request) error {
+	location := req.Args.String("location")
+	fmt.Println("Checking weather for location:", location)
+
+	days := req.Args.Int("days")
+	if days < 1 {
+		return usageErrorf("--days must be at least 1")
+	}
+
+	forecast, err := handleWeather(ctx, req.Bot.currentConfig().Weather, location, days)
+	if err != nil {
+		return fmt.Errorf("getting weather: %w", err)
+	}
+
+	fmt.Println("Sending weather forecast:", forecast)
+	req.Reply(forecast...)
+	return nil
+}
+
+func init() {
+	commands.register(weatherCommand{commandInfo{
+		name:        "weather",
+		signature: Signature{
+			Args: []ArgSpec{
+				{Name: "location", Rest: true},
+			},
+			Flags: []FlagSpec{
+				{Name: "days", Kind: ArgInt, Default: "2"},
+			},
+		},
+		description: "Will return the current weather for the specified location, for the next few days.",
+		permission:  RoleTrusted,
+	}})
+}
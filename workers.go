@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// COMMAND_WORKERS is how many commands can run at once, and
+	// COMMAND_BACKLOG how many more can wait for a worker before we start
+	// turning them away.
+	COMMAND_WORKERS = 4
+	COMMAND_BACKLOG = 32
+	// COMMAND_TIMEOUT is how long a single command gets before its context
+	// is cancelled.
+	COMMAND_TIMEOUT = 30 * time.Second
+)
+
+// job is a unit of work for the worker pool.
+type job func(ctx context.Context)
+
+// workerPool runs slow work, such as commands that hit the database or the
+// BOM FTP server, off the goroutine reading from the server. Each job gets
+// its own deadline, and everything is cancelled when the pool is stopped.
+type workerPool struct {
+	jobs    chan job
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newWorkerPool starts workers goroutines sharing a backlog of queued jobs.
+func newWorkerPool(workers, backlog int, timeout time.Duration) *workerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &workerPool{
+		jobs:    make(chan job, backlog),
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case j := <-p.jobs:
+			ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+			j(ctx)
+			cancel()
+		}
+	}
+}
+
+// submit queues a job. It returns false without blocking if the backlog is
+// full or the pool has been stopped.
+func (p *workerPool) submit(j job) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case p.jobs <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop cancels any running jobs. Jobs still in the backlog are dropped, and
+// workers exit as soon as their current job notices the cancellation.
+func (p *workerPool) stop() {
+	p.cancel()
+}